@@ -0,0 +1,63 @@
+package bench
+
+import (
+	"fmt"
+	"github.com/aybabtme/uniplot/histogram"
+	"golang.org/x/text/message"
+	"io"
+	"sertest/snestime"
+	"sertest/stats"
+	"strings"
+	"time"
+)
+
+// Report summarizes t with outlier filter f and writes a histogram of the kept
+// latencies, the summary statistics in nanoseconds and in frames of region, and the
+// outliers with their iteration numbers.
+func Report(w io.Writer, t *Test, f stats.OutlierFilter, region snestime.Region, p *message.Printer) error {
+	t.Summarize(f, region)
+
+	a, _ := t.samples()
+	kept, _ := f.Split(a)
+	if len(kept) > 0 {
+		hist := histogram.Hist(10, kept)
+		err := histogram.Fprintf(w, hist, histogram.Linear(40), func(v float64) string {
+			return p.Sprintf("% 11dns", time.Duration(v).Nanoseconds())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s := t.Summary
+	ns := func(v float64) string {
+		return p.Sprintf("%dns", int64(v))
+	}
+	p.Fprintf(w, "%s: %d samples, %d failed, %d outliers (%s)\n", t.Name, s.Count, s.Failed, len(s.Outliers), f)
+	if s.Count > 0 {
+		p.Fprintf(w, "  min %s  mean %s  max %s  stddev %s\n", ns(s.Min), ns(s.Mean), ns(s.Max), ns(s.StdDev))
+		p.Fprintf(w, "  median %s  p90 %s  p99 %s  p99.9 %s\n", ns(s.Median), ns(s.P90), ns(s.P99), ns(s.P999))
+		if t.Bytes > 0 {
+			p.Fprintf(w, "  %.1f KiB/s\n", s.BytesPerSec/1024)
+		}
+
+		fs := s.Frames
+		p.Fprintf(w, "  %s frame %s: median %.3f  p99 %.3f  max %.3f frames\n", fs.Region, ns(float64(fs.FrameNS)), fs.Median, fs.P99, fs.Max)
+		p.Fprintf(w, "  %d of %d iterations (%.1f%%) within one frame; %d back-to-back per frame at p99\n",
+			fs.WithinFrame,
+			len(t.LatenciesNS),
+			float64(fs.WithinFrame)*100/float64(len(t.LatenciesNS)),
+			fs.PerFrame,
+		)
+	}
+
+	if len(s.Outliers) > 0 {
+		list := make([]string, len(s.Outliers))
+		for i, o := range s.Outliers {
+			list[i] = p.Sprintf("#%d %s", o.Iteration, ns(float64(o.LatencyNS)))
+		}
+		fmt.Fprintf(w, "  outliers: %s\n", strings.Join(list, ", "))
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,294 @@
+// Package bench records benchmark runs against the FX Pak Pro in machine-readable form
+// so they can be graphed, archived and compared between firmware builds.
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sertest/snestime"
+	"sertest/stats"
+	"sertest/usb2snes"
+	"strconv"
+	"time"
+)
+
+// Run is everything one invocation of a benchmark tool measured.
+type Run struct {
+	Tool    string    `json:"tool"`
+	Started time.Time `json:"started"`
+	Host    Host      `json:"host"`
+	Device  Device    `json:"device"`
+	// Outliers is how outliers were separated from the samples each summary covers.
+	Outliers stats.OutlierFilter `json:"outlier_filter"`
+	// Region is the video standard whose frames the summaries count in.
+	Region snestime.Region `json:"region"`
+	Tests  []*Test         `json:"tests"`
+}
+
+// Host describes the machine the benchmark ran on.
+type Host struct {
+	Hostname  string `json:"hostname"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	GoVersion string `json:"go_version"`
+}
+
+// Device describes the cart the benchmark ran against.
+type Device struct {
+	Port            string `json:"port"`
+	Serial          string `json:"serial"`
+	VID             string `json:"vid,omitempty"`
+	PID             string `json:"pid,omitempty"`
+	Firmware        string `json:"firmware,omitempty"`
+	FirmwareVersion uint32 `json:"firmware_version,omitempty"`
+	ROM             string `json:"rom,omitempty"`
+}
+
+// Test is one measured command with fixed parameters.
+type Test struct {
+	Name string `json:"name"`
+	// Params are the settings that make this test comparable to another run's.
+	Params map[string]interface{} `json:"params"`
+	// Bytes is the payload each iteration transfers.
+	Bytes int `json:"bytes"`
+	// LatenciesNS holds each iteration's latency in nanoseconds, or -1 if it failed.
+	LatenciesNS []int64          `json:"latencies_ns"`
+	Errors      []IterationError `json:"errors,omitempty"`
+	Summary     Summary          `json:"summary"`
+}
+
+// IterationError records why an iteration failed.
+type IterationError struct {
+	Iteration int    `json:"iteration"`
+	Err       string `json:"error"`
+}
+
+// Summary condenses the successful iterations of a Test that are not outliers.
+type Summary struct {
+	stats.Summary
+	Failed   int          `json:"failed"`
+	Outliers []Outlier    `json:"outliers,omitempty"`
+	Frames   FrameSummary `json:"frames"`
+}
+
+// FrameSummary answers whether a test's command fits in a video frame.
+type FrameSummary struct {
+	Region  snestime.Region `json:"region"`
+	FrameNS int64           `json:"frame_ns"`
+	// Median, P99 and Max are latencies in frames.
+	Median float64 `json:"median_frames"`
+	P99    float64 `json:"p99_frames"`
+	Max    float64 `json:"max_frames"`
+	// WithinFrame counts the iterations, outliers included, that finished within one
+	// frame; failed iterations do not.
+	WithinFrame int `json:"within_frame"`
+	// PerFrame is how many back-to-back commands fit in a frame at p99 latency.
+	PerFrame int `json:"per_frame_p99"`
+}
+
+// Outlier is an iteration left out of the summary.
+type Outlier struct {
+	Iteration int   `json:"iteration"`
+	LatencyNS int64 `json:"latency_ns"`
+}
+
+// LocalHost describes the machine this is running on.
+func LocalHost() Host {
+	hostname, _ := os.Hostname()
+	return Host{
+		Hostname:  hostname,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// NewRun starts recording a run of tool on the local host.
+func NewRun(tool string) *Run {
+	return &Run{
+		Tool:    tool,
+		Started: time.Now().UTC(),
+		Host:    LocalHost(),
+	}
+}
+
+// Load reads a run written by Save.
+func Load(name string) (*Run, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &Run{}
+	if err = json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// SetInfo fills in the device's firmware details from an OpINFO reply.
+func (r *Run) SetInfo(info usb2snes.Info) {
+	r.Device.Firmware = info.Version
+	r.Device.FirmwareVersion = info.FirmwareVersion
+	r.Device.ROM = info.ROM
+}
+
+// NewTest adds a test with room for iterations latencies, all marked failed until
+// recorded.
+func (r *Run) NewTest(name string, bytes int, iterations int, params map[string]interface{}) *Test {
+	t := &Test{
+		Name:        name,
+		Params:      params,
+		Bytes:       bytes,
+		LatenciesNS: make([]int64, iterations),
+	}
+	for i := range t.LatenciesNS {
+		t.LatenciesNS[i] = -1
+	}
+	r.Tests = append(r.Tests, t)
+	return t
+}
+
+// Record stores the latency of iteration i.
+func (t *Test) Record(i int, d time.Duration) {
+	t.LatenciesNS[i] = d.Nanoseconds()
+}
+
+// Fail marks iteration i failed with err.
+func (t *Test) Fail(i int, err error) {
+	t.LatenciesNS[i] = -1
+	t.Errors = append(t.Errors, IterationError{Iteration: i, Err: err.Error()})
+}
+
+// Truncate drops the iterations from n on, e.g. when a run is interrupted.
+func (t *Test) Truncate(n int) {
+	if n < len(t.LatenciesNS) {
+		t.LatenciesNS = t.LatenciesNS[:n]
+	}
+}
+
+// Latencies returns the successful iterations' latencies in nanoseconds.
+func (t *Test) Latencies() []float64 {
+	a, _ := t.samples()
+	return a
+}
+
+// Key identifies t by its parameters so the same test can be found in another run. A
+// test without parameters is identified by its name.
+func (t *Test) Key() string {
+	if len(t.Params) == 0 {
+		return t.Name
+	}
+	// encoding/json sorts map keys so equal parameters always encode the same:
+	b, err := json.Marshal(t.Params)
+	if err != nil {
+		return t.Name
+	}
+	return string(b)
+}
+
+// samples returns the successful iterations' latencies and their iteration numbers.
+func (t *Test) samples() (a []float64, iterations []int) {
+	a = make([]float64, 0, len(t.LatenciesNS))
+	for i, ns := range t.LatenciesNS {
+		if ns >= 0 {
+			a = append(a, float64(ns))
+			iterations = append(iterations, i)
+		}
+	}
+	return
+}
+
+// Summarize computes t.Summary from the recorded latencies after separating the
+// outliers f finds, counting frames of region.
+func (t *Test) Summarize(f stats.OutlierFilter, region snestime.Region) {
+	a, iterations := t.samples()
+	kept, outliers := f.Split(a)
+
+	t.Summary = Summary{
+		Summary: stats.Summarize(kept, t.Bytes),
+		Failed:  len(t.LatenciesNS) - len(a),
+	}
+	for _, i := range outliers {
+		t.Summary.Outliers = append(t.Summary.Outliers, Outlier{Iteration: iterations[i], LatencyNS: int64(a[i])})
+	}
+
+	frame := region.FrameDuration()
+	fs := FrameSummary{Region: region, FrameNS: frame.Nanoseconds()}
+	if s := t.Summary.Summary; s.Count > 0 {
+		frames := func(ns float64) float64 {
+			return region.Frames(time.Duration(ns))
+		}
+		fs.Median, fs.P99, fs.Max = frames(s.Median), frames(s.P99), frames(s.Max)
+		if s.P99 > 0 {
+			fs.PerFrame = int(float64(frame.Nanoseconds()) / s.P99)
+		}
+	}
+	for _, v := range a {
+		if v <= float64(frame.Nanoseconds()) {
+			fs.WithinFrame++
+		}
+	}
+	t.Summary.Frames = fs
+}
+
+// Save summarizes every test and writes the run to base+".json" and, one row per
+// iteration, to base+".csv". It returns the names of the files written.
+func (r *Run) Save(base string) ([]string, error) {
+	for _, t := range r.Tests {
+		t.Summarize(r.Outliers, r.Region)
+	}
+
+	names := []string{base + ".json", base + ".csv"}
+	if err := r.writeJSON(names[0]); err != nil {
+		return nil, err
+	}
+	if err := r.writeCSV(names[1]); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (r *Run) writeJSON(name string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
+
+func (r *Run) writeCSV(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"tool", "test", "bytes", "iteration", "latency_ns", "error"})
+	for _, t := range r.Tests {
+		errs := map[int]string{}
+		for _, e := range t.Errors {
+			errs[e.Iteration] = e.Err
+		}
+		for i, ns := range t.LatenciesNS {
+			latency := ""
+			if ns >= 0 {
+				latency = strconv.FormatInt(ns, 10)
+			}
+			_ = w.Write([]string{r.Tool, t.Name, strconv.Itoa(t.Bytes), strconv.Itoa(i), latency, errs[i]})
+		}
+	}
+	w.Flush()
+
+	err = w.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"os"
+	"sertest/bench"
+	"sertest/stats"
+	"text/tabwriter"
+	"time"
+)
+
+func cmdCOMPARE(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	threshold := fs.Float64("threshold", 5, "median slowdown in percent that counts as a regression")
+	alpha := fs.Float64("alpha", 0.05, "significance level a slowdown must reach to count")
+	outliers := fs.String("outliers", "iqr", "outlier filter applied to every file: none, iqr[:k], mad[:k] or fixed:duration")
+	if fs.Parse(args) != nil || fs.NArg() < 2 {
+		return errUsage
+	}
+	if *threshold < 0 || *alpha <= 0 || *alpha >= 1 {
+		return fmt.Errorf("compare: -threshold must be >= 0 and -alpha between 0 and 1")
+	}
+
+	filter, err := stats.ParseOutlierFilter(*outliers)
+	if err != nil {
+		return err
+	}
+
+	base, err := bench.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	p := message.NewPrinter(language.AmericanEnglish)
+	regressions := 0
+	for _, name := range fs.Args()[1:] {
+		r, err := bench.Load(name)
+		if err != nil {
+			return err
+		}
+
+		p.Printf("baseline:  %s\n", describe(fs.Arg(0), base))
+		p.Printf("candidate: %s\n\n", describe(name, r))
+		regressions += compare(p, base, r, filter, *threshold, *alpha)
+		fmt.Println()
+	}
+
+	if regressions > 0 {
+		return fmt.Errorf("%w: %d tests more than %g%% slower at p < %g", errRegression, regressions, *threshold, *alpha)
+	}
+	return nil
+}
+
+func describe(name string, r *bench.Run) string {
+	s := fmt.Sprintf("%s (%s, %s", name, r.Tool, r.Started.Format(time.RFC3339))
+	if r.Device.Firmware != "" {
+		s += ", firmware " + r.Device.Firmware
+	}
+	return s + ")"
+}
+
+// keyed indexes the tests of r by their parameters; a repeated key gets a #n suffix so
+// that repeats line up in order.
+func keyed(r *bench.Run) (keys []string, tests map[string]*bench.Test) {
+	tests = map[string]*bench.Test{}
+	seen := map[string]int{}
+	for _, t := range r.Tests {
+		key := t.Key()
+		if n := seen[key]; n > 0 {
+			seen[key]++
+			key = fmt.Sprintf("%s#%d", key, n+1)
+		} else {
+			seen[key] = 1
+		}
+		keys = append(keys, key)
+		tests[key] = t
+	}
+	return
+}
+
+// compare prints the tests of r next to those of base and returns how many regressed.
+func compare(p *message.Printer, base, r *bench.Run, f stats.OutlierFilter, threshold, alpha float64) int {
+	baseKeys, baseTests := keyed(base)
+	keys, tests := keyed(r)
+
+	ns := func(v float64) string {
+		return p.Sprintf("%dns", int64(v))
+	}
+	pct := func(from, to float64) string {
+		if from == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%+.1f%%", (to-from)/from*100)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "test\tbaseline median\tmedian\tΔ median\tΔ p90\tΔ p99\tp-value\t\t")
+
+	regressions := 0
+	for _, key := range baseKeys {
+		bt := baseTests[key]
+		t, ok := tests[key]
+		if !ok {
+			continue
+		}
+
+		a, _ := f.Split(bt.Latencies())
+		b, _ := f.Split(t.Latencies())
+		bs, s := stats.Summarize(a, bt.Bytes), stats.Summarize(b, t.Bytes)
+		if bs.Count == 0 || s.Count == 0 {
+			fmt.Fprintf(w, "%s\t\t\t\t\t\t\tno samples\t\n", t.Name)
+			continue
+		}
+
+		_, pValue := stats.MannWhitneyU(a, b)
+		change := (s.Median - bs.Median) / bs.Median * 100
+		verdict := ""
+		switch {
+		case pValue >= alpha:
+		case change > threshold:
+			verdict = "REGRESSED"
+			regressions++
+		case change < -threshold:
+			verdict = "improved"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.4f\t%s\t\n",
+			t.Name,
+			ns(bs.Median),
+			ns(s.Median),
+			pct(bs.Median, s.Median),
+			pct(bs.P90, s.P90),
+			pct(bs.P99, s.P99),
+			pValue,
+			verdict,
+		)
+	}
+	w.Flush()
+
+	for _, key := range baseKeys {
+		if _, ok := tests[key]; !ok {
+			fmt.Printf("only in baseline: %s\n", baseTests[key].Name)
+		}
+	}
+	for _, key := range keys {
+		if _, ok := baseTests[key]; !ok {
+			fmt.Printf("only in candidate: %s\n", tests[key].Name)
+		}
+	}
+	return regressions
+}
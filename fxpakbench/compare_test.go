@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sertest/bench"
+	"sertest/stats"
+	"testing"
+	"time"
+)
+
+// addTest adds a test to r whose 20 iterations take about base ns.
+func addTest(r *bench.Run, name string, size int, base time.Duration) {
+	t := r.NewTest(name, size, 20, map[string]interface{}{"op": "vget", "size": size})
+	for i := range t.LatenciesNS {
+		t.Record(i, base+time.Duration(i*7%13))
+	}
+}
+
+// synthetic returns a baseline and a candidate that has "a" 20% slower, "b" unchanged,
+// "c" 20% faster, "d" only in the baseline and "e" only in the candidate.
+func synthetic() (base, r *bench.Run) {
+	base, r = bench.NewRun("test"), bench.NewRun("test")
+	addTest(base, "a", 1, 1000)
+	addTest(base, "b", 2, 1000)
+	addTest(base, "c", 3, 1000)
+	addTest(base, "d", 4, 1000)
+	// listed in another order, which must not matter:
+	addTest(r, "e", 5, 1000)
+	addTest(r, "c", 3, 800)
+	addTest(r, "b", 2, 1000)
+	addTest(r, "a", 1, 1200)
+	return
+}
+
+func TestKeyed(t *testing.T) {
+	r := bench.NewRun("test")
+	addTest(r, "a", 1, 1000)
+	addTest(r, "a again", 1, 1000)
+	addTest(r, "b", 2, 1000)
+	r.NewTest("plain", 1, 1, nil)
+
+	keys, tests := keyed(r)
+	want := []string{
+		`{"op":"vget","size":1}`,
+		`{"op":"vget","size":1}#2`,
+		`{"op":"vget","size":2}`,
+		"plain",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys = %q, want %q", keys, want)
+	}
+	if tests[want[1]].Name != "a again" {
+		t.Errorf("%s is %q, want the repeat", want[1], tests[want[1]].Name)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	none := stats.OutlierFilter{Method: stats.OutliersNone}
+	tests := []struct {
+		name      string
+		threshold float64
+		alpha     float64
+		want      int
+	}{
+		{"defaults", 5, 0.05, 1},
+		{"below threshold", 25, 0.05, 0},
+		{"not significant", 5, 1e-12, 0},
+		{"zero threshold", 0, 0.05, 1},
+	}
+	p := message.NewPrinter(language.AmericanEnglish)
+	for _, tt := range tests {
+		base, r := synthetic()
+		if got := compare(p, base, r, none, tt.threshold, tt.alpha); got != tt.want {
+			t.Errorf("%s: %d regressions, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompareExitCode(t *testing.T) {
+	dir := t.TempDir()
+	base, r := synthetic()
+	for name, run := range map[string]*bench.Run{"base": base, "candidate": r, "same": base} {
+		if _, err := run.Save(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(args []string, fs *flag.FlagSet) {
+		os.Args, flag.CommandLine = args, fs
+	}(os.Args, flag.CommandLine)
+
+	for _, tt := range []struct {
+		candidate string
+		want      int
+	}{
+		{"candidate", exitRegression},
+		{"same", exitOK},
+	} {
+		os.Args = []string{"fxpakbench", "compare", filepath.Join(dir, "base.json"), filepath.Join(dir, tt.candidate+".json")}
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if got := run(); got != tt.want {
+			t.Errorf("compare against %s exited %d, want %d", tt.candidate, got, tt.want)
+		}
+	}
+
+	err := cmdCOMPARE([]string{filepath.Join(dir, "base.json"), filepath.Join(dir, "candidate.json")})
+	if !errors.Is(err, errRegression) {
+		t.Errorf("cmdCOMPARE = %v, want errRegression", err)
+	}
+}
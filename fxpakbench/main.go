@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit codes:
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitUsage      = 2
+	exitRegression = 4
+)
+
+var (
+	errUsage      = errors.New("usage")
+	errRegression = errors.New("regression")
+)
+
+type command struct {
+	name  string
+	args  string
+	about string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"compare", "[-threshold pct] [-alpha p] [-outliers filter] <baseline.json> <result.json>...", "compare benchmark results against a baseline", cmdCOMPARE},
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: fxpakbench <command> [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(o, "  %-8s %s\n           %s\n", cmd.name, cmd.args, cmd.about)
+	}
+	fmt.Fprintf(o, "\nexit status is %d if a result regressed past the threshold\n", exitRegression)
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("fxpakbench: ")
+
+	if flag.NArg() < 1 {
+		usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	err := cmd.run(flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: fxpakbench %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if errors.Is(err, errRegression) {
+		log.Println(err)
+		return exitRegression
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"sertest/usb2snes"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for the ROM to start")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fxpakboot [flags] <path>\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return exitUsage
+	}
+	romPath := flag.Arg(0)
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	log.Printf("%s: boot '%s'\n", c.Name, romPath)
+	start := time.Now()
+	if err = c.Boot(romPath, *timeout); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	log.Printf("%s: '%s' running after %v\n", c.Name, romPath, time.Since(start).Round(time.Millisecond))
+	return exitOK
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"sertest/usb2snes"
+	"strconv"
+	"strings"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	name  string
+	args  string
+	about string
+	run   func(c *usb2snes.Conn, args []string) error
+}
+
+var commands = []command{
+	{"show", "", "show the decoded settings", cmdSHOW},
+	{"get", "<name>", "print one setting", cmdGET},
+	{"set", "<name>=<value>...", "change settings", cmdSET},
+	{"dump", "[file.yml]", "save all settings, decoded and raw", cmdDUMP},
+	{"restore", "[-force] <file.yml>", "write settings saved by dump with the same firmware", cmdRESTORE},
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: fxpakcfg [-port name] <command> [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(o, "  %-7s %-20s %s\n", cmd.name, cmd.args, cmd.about)
+	}
+	fmt.Fprintf(o, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("fxpakcfg: ")
+
+	if flag.NArg() < 1 {
+		usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	err = cmd.run(c, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: fxpakcfg %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
+
+func cmdSHOW(c *usb2snes.Conn, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	b, err := c.ReadConfig()
+	if err != nil {
+		return err
+	}
+	for _, f := range usb2snes.ConfigFields {
+		fmt.Printf("%-24s %s\n", f.Name, f.Get(b))
+	}
+	return nil
+}
+
+func lookup(name string) (usb2snes.ConfigField, error) {
+	f, ok := usb2snes.LookupConfigField(name)
+	if !ok {
+		return f, fmt.Errorf("unknown setting %q", name)
+	}
+	return f, nil
+}
+
+func cmdGET(c *usb2snes.Conn, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	f, err := lookup(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := c.ReadConfig()
+	if err != nil {
+		return err
+	}
+	fmt.Println(f.Get(b))
+	return nil
+}
+
+func cmdSET(c *usb2snes.Conn, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	b, err := c.ReadConfig()
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return errUsage
+		}
+		f, err := lookup(arg[:i])
+		if err != nil {
+			return err
+		}
+		if err = f.Set(b, arg[i+1:]); err != nil {
+			return err
+		}
+	}
+	return c.WriteConfig(b)
+}
+
+// dump is the file format of dump and restore. Raw holds the whole settings block so
+// that bytes outside the known fields survive a round trip; Settings is applied on top
+// of it, so edits to either are picked up by restore. The block's layout can change
+// between firmware versions so Firmware records the one it was read from.
+type dump struct {
+	Firmware string        `yaml:"firmware"`
+	Settings yaml.MapSlice `yaml:"settings"`
+	Raw      []string      `yaml:"raw"`
+}
+
+func cmdDUMP(c *usb2snes.Conn, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	info, err := c.Info()
+	if err != nil {
+		return err
+	}
+	b, err := c.ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	d := dump{Firmware: info.Version}
+	for _, f := range usb2snes.ConfigFields {
+		var v interface{} = f.Get(b)
+		if f.Kind == usb2snes.ConfigUint {
+			v, _ = strconv.ParseUint(f.Get(b), 10, 64)
+		}
+		d.Settings = append(d.Settings, yaml.MapItem{Key: f.Name, Value: v})
+	}
+	for i := 0; i < len(b); i += 32 {
+		d.Raw = append(d.Raw, hex.EncodeToString(b[i:i+32]))
+	}
+
+	out, err := yaml.Marshal(&d)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return ioutil.WriteFile(args[0], out, 0644)
+}
+
+func cmdRESTORE(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := fs.Bool("force", false, "restore even if the dump was taken with different firmware")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	args = fs.Args()
+	in, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	var d dump
+	if err = yaml.UnmarshalStrict(in, &d); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	info, err := c.Info()
+	if err != nil {
+		return err
+	}
+	if d.Firmware != info.Version && !*force {
+		if d.Firmware == "" {
+			return fmt.Errorf("%s: no firmware version recorded; the device runs %s (use -force to restore anyway)", args[0], info.Version)
+		}
+		return fmt.Errorf("%s: dumped from firmware %s but the device runs %s (use -force to restore anyway)", args[0], d.Firmware, info.Version)
+	}
+
+	var b []byte
+	if len(d.Raw) > 0 {
+		if b, err = hex.DecodeString(strings.Join(d.Raw, "")); err != nil {
+			return fmt.Errorf("%s: raw: %w", args[0], err)
+		}
+		if len(b) != usb2snes.ConfigSize {
+			return fmt.Errorf("%s: raw: expected %d bytes, got %d", args[0], usb2snes.ConfigSize, len(b))
+		}
+	} else if b, err = c.ReadConfig(); err != nil {
+		return err
+	}
+
+	for _, item := range d.Settings {
+		name := fmt.Sprint(item.Key)
+		f, err := lookup(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		if err = f.Set(b, fmt.Sprint(item.Value)); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+
+	return c.WriteConfig(b)
+}
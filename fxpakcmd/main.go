@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sertest/usb2snes"
+	"strconv"
+	"strings"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	name  string
+	args  string
+	about string
+	run   func(c *usb2snes.Conn, args []string) error
+}
+
+var commands = []command{
+	{"get", "<addr> <size>", "hex dump SpaceCMD memory", cmdGET},
+	{"put", "[-clrx] [-setx] <addr> <hex | @file>", "write SpaceCMD memory", cmdPUT},
+	{"exec", "<hex | @file>", "upload a routine to the command region and run it from NMI", cmdEXEC},
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: fxpakcmd [-port name] <command> [args]\n\n")
+	fmt.Fprintf(o, "Data is given as hex bytes, e.g. 9C002C6CEAFF, or read from a file with @file.\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(o, "  %-5s %-36s %s\n", cmd.name, cmd.args, cmd.about)
+	}
+	fmt.Fprintf(o, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() < 1 {
+		usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	err = cmd.run(c, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: fxpakcmd %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
+
+func parseUint(s string) (uint32, error) {
+	s = strings.TrimPrefix(s, "$")
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad hex number %q", s)
+	}
+	return uint32(n), nil
+}
+
+// parseData decodes hex bytes or, with a leading @, reads a file.
+func parseData(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "@") {
+		return ioutil.ReadFile(s[1:])
+	}
+	b, err := hex.DecodeString(strings.NewReplacer(" ", "", ",", "").Replace(s))
+	if err != nil {
+		return nil, fmt.Errorf("bad hex data: %w", err)
+	}
+	return b, nil
+}
+
+func cmdGET(c *usb2snes.Conn, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	addr, err := parseUint(args[0])
+	if err != nil {
+		return err
+	}
+	size, err := parseUint(args[1])
+	if err != nil {
+		return err
+	}
+
+	b, err := c.GetCMD(addr, size)
+	if err != nil {
+		return err
+	}
+	fmt.Print(hex.Dump(b))
+	return nil
+}
+
+func cmdPUT(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	clrx := fs.Bool("clrx", false, "clear the execute flag before writing")
+	setx := fs.Bool("setx", false, "set the execute flag after writing")
+	if fs.Parse(args) != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+
+	addr, err := parseUint(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	data, err := parseData(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	flags := usb2snes.FlagNONE
+	if *clrx {
+		flags |= usb2snes.FlagCLRX
+	}
+	if *setx {
+		flags |= usb2snes.FlagSETX
+	}
+
+	log.Printf("%s: write %d bytes to CMD $%06x\n", c.Name, len(data), addr)
+	return c.PutCMD(addr, data, flags)
+}
+
+func cmdEXEC(c *usb2snes.Conn, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	code, err := parseData(args[0])
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: exec %d bytes:\n%s", c.Name, len(code), hex.Dump(code))
+	return c.ExecCMD(code)
+}
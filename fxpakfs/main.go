@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sertest/usb2snes"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	name  string
+	args  string
+	about string
+	run   func(c *usb2snes.Conn, args []string) error
+}
+
+var commands = []command{
+	{"ls", "[-l] [path]", "list a directory", cmdLS},
+	{"get", "[-q] <remote> [local]", "download a file", cmdGET},
+	{"put", "[-q] <local> [remote]", "upload a file", cmdPUT},
+	{"mkdir", "<path>...", "create directories", cmdMKDIR},
+	{"rm", "<path>...", "delete files or empty directories", cmdRM},
+	{"mv", "<path> <new path>", "rename a file or directory", cmdMV},
+	{"sync", "[-n] [-delete] [-hash] [-q] <local dir> <remote dir>", "mirror a local directory to the cart\n         (downloads every file present on both sides to compare it)", cmdSYNC},
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: fxpakfs [-port name] <command> [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(o, "  %-6s %s\n         %s\n", cmd.name, cmd.args, cmd.about)
+	}
+	fmt.Fprintf(o, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("fxpakfs: ")
+
+	if flag.NArg() < 1 {
+		usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	err = cmd.run(c, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: fxpakfs %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
+
+func cmdLS(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := fs.Bool("l", false, "show file sizes (downloads every file to measure it)")
+	if fs.Parse(args) != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+
+	dir := "/"
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	entries, err := c.List(dir)
+	if err != nil {
+		return fmt.Errorf("ls %s: %w", dir, err)
+	}
+
+	for _, e := range entries {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+
+		if e.IsDir() {
+			if *long {
+				fmt.Printf("d %10s %s/\n", "-", e.Name)
+			} else {
+				fmt.Printf("d %s/\n", e.Name)
+			}
+			continue
+		}
+
+		if *long {
+			size, err := c.FileSize(path.Join(dir, e.Name))
+			if err != nil {
+				return fmt.Errorf("ls %s: %w", path.Join(dir, e.Name), err)
+			}
+			fmt.Printf("f %10d %s\n", size, e.Name)
+		} else {
+			fmt.Printf("f %s\n", e.Name)
+		}
+	}
+	return nil
+}
+
+func cmdGET(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	quiet := fs.Bool("q", false, "do not show progress")
+	if fs.Parse(args) != nil || fs.NArg() < 1 || fs.NArg() > 2 {
+		return errUsage
+	}
+
+	remote := fs.Arg(0)
+	local := path.Base(remote)
+	if fs.NArg() == 2 {
+		local = fs.Arg(1)
+		if fi, err := os.Stat(local); err == nil && fi.IsDir() {
+			local = filepath.Join(local, path.Base(remote))
+		}
+	}
+
+	f, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.GetFile(remote, f, progress(*quiet, remote))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(local)
+		return fmt.Errorf("get %s: %w", remote, err)
+	}
+	return nil
+}
+
+func cmdPUT(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	quiet := fs.Bool("q", false, "do not show progress")
+	if fs.Parse(args) != nil || fs.NArg() < 1 || fs.NArg() > 2 {
+		return errUsage
+	}
+
+	local := fs.Arg(0)
+	remote := "/"
+	if fs.NArg() == 2 {
+		remote = fs.Arg(1)
+	}
+	if strings.HasSuffix(remote, "/") {
+		remote += filepath.Base(local)
+	}
+
+	if err := putFile(c, local, remote, progress(*quiet, remote)); err != nil {
+		return fmt.Errorf("put %s: %w", remote, err)
+	}
+	return nil
+}
+
+func putFile(c *usb2snes.Conn, local, remote string, progress usb2snes.Progress) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s: is a directory", local)
+	}
+
+	return c.PutFile(remote, f, int(fi.Size()), progress)
+}
+
+func cmdMKDIR(c *usb2snes.Conn, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	for _, p := range args {
+		if err := c.Mkdir(p); err != nil {
+			return fmt.Errorf("mkdir %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func cmdRM(c *usb2snes.Conn, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	for _, p := range args {
+		if err := c.Remove(p); err != nil {
+			return fmt.Errorf("rm %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func cmdMV(c *usb2snes.Conn, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	if err := c.Rename(args[0], args[1]); err != nil {
+		return fmt.Errorf("mv %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// progress returns a usb2snes.Progress that redraws a single status line on stderr.
+func progress(quiet bool, name string) usb2snes.Progress {
+	if quiet {
+		return nil
+	}
+	return func(done, total int) {
+		pct := 100
+		if total > 0 {
+			pct = done * 100 / total
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %d / %d bytes (%3d%%)", name, done, total, pct)
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
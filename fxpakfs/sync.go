@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"sertest/usb2snes"
+)
+
+type syncOp int
+
+const (
+	syncMKDIR syncOp = iota
+	syncPUT
+	syncRM
+)
+
+func (op syncOp) String() string {
+	return [...]string{"mkdir", "put", "rm"}[op]
+}
+
+type syncAction struct {
+	op     syncOp
+	local  string
+	remote string
+	reason string
+}
+
+type syncer struct {
+	c      *usb2snes.Conn
+	hash   bool
+	delete bool
+
+	actions []syncAction
+	// visiting holds the resolved local directories being walked, to catch symlink
+	// loops.
+	visiting map[string]bool
+}
+
+func cmdSYNC(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "dry run; only list what would change")
+	del := fs.Bool("delete", false, "delete remote files and directories that do not exist locally")
+	hash := fs.Bool("hash", false, "also compare contents of same-sized files by SHA-256")
+	quiet := fs.Bool("q", false, "do not show progress")
+	if fs.Parse(args) != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+
+	localDir, remoteDir := fs.Arg(0), fs.Arg(1)
+	if fi, err := os.Stat(localDir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("sync %s: not a directory", localDir)
+	}
+
+	s := &syncer{c: c, hash: *hash, delete: *del, visiting: map[string]bool{}}
+
+	// the remote root is compared as a child of its parent so that a missing root
+	// turns into a mkdir:
+	parent, base := path.Split(path.Clean(remoteDir))
+	if base == "" || base == "/" {
+		if err := s.syncDir(localDir, "/", true); err != nil {
+			return err
+		}
+	} else {
+		entries, err := c.List(parent)
+		if err != nil {
+			return fmt.Errorf("ls %s: %w", parent, err)
+		}
+		exists := false
+		for _, e := range entries {
+			if e.Name != base {
+				continue
+			}
+			if !e.IsDir() {
+				return fmt.Errorf("sync %s: not a directory", remoteDir)
+			}
+			exists = true
+		}
+		if !exists {
+			s.add(syncMKDIR, localDir, path.Clean(remoteDir), "missing")
+		}
+		if err := s.syncDir(localDir, path.Clean(remoteDir), exists); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range s.actions {
+		fmt.Printf("%-5s %s (%s)\n", a.op, a.remote, a.reason)
+		if *dryRun {
+			continue
+		}
+
+		var err error
+		switch a.op {
+		case syncMKDIR:
+			err = c.Mkdir(a.remote)
+		case syncRM:
+			err = c.Remove(a.remote)
+		case syncPUT:
+			err = putFile(c, a.local, a.remote, progress(*quiet, a.remote))
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", a.op, a.remote, err)
+		}
+	}
+
+	if len(s.actions) == 0 {
+		fmt.Println("up to date")
+	}
+	return nil
+}
+
+func (s *syncer) add(op syncOp, local, remote, reason string) {
+	s.actions = append(s.actions, syncAction{op: op, local: local, remote: remote, reason: reason})
+}
+
+// syncDir plans the actions that make remoteDir mirror localDir. If remoteExists is
+// false the remote directory is treated as empty.
+func (s *syncer) syncDir(localDir, remoteDir string, remoteExists bool) error {
+	resolved, err := filepath.EvalSymlinks(localDir)
+	if err != nil {
+		return err
+	}
+	if s.visiting[resolved] {
+		log.Printf("skipping %s: symlink loop\n", localDir)
+		return nil
+	}
+	s.visiting[resolved] = true
+	defer delete(s.visiting, resolved)
+
+	local, err := ioutil.ReadDir(localDir)
+	if err != nil {
+		return err
+	}
+
+	remote := map[string]usb2snes.DirEntry{}
+	if remoteExists {
+		entries, err := s.c.List(remoteDir)
+		if err != nil {
+			return fmt.Errorf("ls %s: %w", remoteDir, err)
+		}
+		for _, e := range entries {
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+			remote[e.Name] = e
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, fi := range local {
+		name := fi.Name()
+		seen[name] = true
+		lp := filepath.Join(localDir, name)
+		rp := path.Join(remoteDir, name)
+		re, ok := remote[name]
+
+		// ReadDir does not follow symlinks:
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(lp)
+			if err != nil {
+				log.Printf("skipping %s: %v\n", lp, err)
+				continue
+			}
+			fi = target
+		}
+
+		if fi.IsDir() {
+			if ok && !re.IsDir() {
+				s.add(syncRM, "", rp, "replaced by directory")
+				ok = false
+			}
+			if !ok {
+				s.add(syncMKDIR, lp, rp, "missing")
+			}
+			if err = s.syncDir(lp, rp, ok); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !fi.Mode().IsRegular() {
+			log.Printf("skipping %s: not a regular file\n", lp)
+			continue
+		}
+
+		if ok && re.IsDir() {
+			if err = s.removeTree(rp, "replaced by file"); err != nil {
+				return err
+			}
+			ok = false
+		}
+		if !ok {
+			s.add(syncPUT, lp, rp, "missing")
+			continue
+		}
+
+		reason, err := s.compare(lp, rp, fi.Size())
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			s.add(syncPUT, lp, rp, reason)
+		}
+	}
+
+	if !s.delete {
+		return nil
+	}
+	for _, name := range sortedNames(remote) {
+		if seen[name] {
+			continue
+		}
+		rp := path.Join(remoteDir, name)
+		if remote[name].IsDir() {
+			if err = s.removeTree(rp, "extraneous"); err != nil {
+				return err
+			}
+		} else {
+			s.add(syncRM, "", rp, "extraneous")
+		}
+	}
+	return nil
+}
+
+// compare returns why the remote file differs from the local one or "" if it does not.
+// The LS reply carries no sizes and a GET cannot be cancelled, so either way this
+// downloads the whole remote file; -hash only adds hashing both copies.
+func (s *syncer) compare(localPath, remotePath string, localSize int64) (string, error) {
+	if !s.hash {
+		remoteSize, err := s.c.FileSize(remotePath)
+		if err != nil {
+			return "", fmt.Errorf("get %s: %w", remotePath, err)
+		}
+		if int64(remoteSize) != localSize {
+			return fmt.Sprintf("size %d != %d", remoteSize, localSize), nil
+		}
+		return "", nil
+	}
+
+	h := sha256.New()
+	remoteSize, err := s.c.GetFile(remotePath, h, nil)
+	if err != nil {
+		return "", fmt.Errorf("get %s: %w", remotePath, err)
+	}
+	if int64(remoteSize) != localSize {
+		return fmt.Sprintf("size %d != %d", remoteSize, localSize), nil
+	}
+
+	localHash, err := hashFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(localHash, h.Sum(nil)) {
+		return "content differs", nil
+	}
+	return "", nil
+}
+
+// removeTree plans removal of a remote directory and everything below it.
+func (s *syncer) removeTree(remoteDir string, reason string) error {
+	entries, err := s.c.List(remoteDir)
+	if err != nil {
+		return fmt.Errorf("ls %s: %w", remoteDir, err)
+	}
+	for _, e := range entries {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+		rp := path.Join(remoteDir, e.Name)
+		if e.IsDir() {
+			if err = s.removeTree(rp, reason); err != nil {
+				return err
+			}
+		} else {
+			s.add(syncRM, "", rp, reason)
+		}
+	}
+	s.add(syncRM, "", remoteDir, reason)
+	return nil
+}
+
+func sortedNames(m map[string]usb2snes.DirEntry) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hashFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
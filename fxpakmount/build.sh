@@ -0,0 +1,2 @@
+#!/bin/bash
+GOOS=linux GOARCH=amd64 go build -ldflags="-s -w"
@@ -0,0 +1,75 @@
+//go:build linux
+// +build linux
+
+package main
+
+// fileCache keeps the contents of recently read or written files up to a total size,
+// evicting the least recently used first.
+type fileCache struct {
+	limit int
+	size  int
+	tick  uint64
+
+	entries map[string]*fileCacheEntry
+}
+
+type fileCacheEntry struct {
+	data []byte
+	used uint64
+}
+
+func newFileCache(limit int) *fileCache {
+	return &fileCache{limit: limit, entries: map[string]*fileCacheEntry{}}
+}
+
+func (c *fileCache) get(p string) ([]byte, bool) {
+	e, ok := c.entries[p]
+	if !ok {
+		return nil, false
+	}
+	c.tick++
+	e.used = c.tick
+	return e.data, true
+}
+
+func (c *fileCache) put(p string, data []byte) {
+	c.remove(p)
+	if len(data) > c.limit {
+		return
+	}
+
+	for c.size+len(data) > c.limit {
+		c.evict()
+	}
+
+	c.tick++
+	c.entries[p] = &fileCacheEntry{data: data, used: c.tick}
+	c.size += len(data)
+}
+
+func (c *fileCache) remove(p string) {
+	if e, ok := c.entries[p]; ok {
+		c.size -= len(e.data)
+		delete(c.entries, p)
+	}
+}
+
+func (c *fileCache) removeTree(dir string) {
+	for p := range c.entries {
+		if p == dir || isBelow(p, dir) {
+			c.remove(p)
+		}
+	}
+}
+
+// evict drops the least recently used entry.
+func (c *fileCache) evict() {
+	oldest := ""
+	var used uint64
+	for p, e := range c.entries {
+		if oldest == "" || e.used < used {
+			oldest, used = p, e.used
+		}
+	}
+	c.remove(oldest)
+}
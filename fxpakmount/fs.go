@@ -0,0 +1,425 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"sertest/usb2snes"
+)
+
+// FS serves the cart's SD card over FUSE. The device handles one command at a time so
+// every request that talks to it holds mu.
+type FS struct {
+	mu sync.Mutex
+	c  *usb2snes.Conn
+
+	// exactSizes makes Attr download files whose size is not yet known; otherwise they
+	// report a size of 0 until first read and are opened for direct I/O.
+	exactSizes bool
+	// dirTTL is how long a listing is reused; changes made through the mount drop it
+	// sooner. 0 lists the directory every time.
+	dirTTL time.Duration
+
+	dirs  map[string]*dirCache
+	files *fileCache
+}
+
+type dirCache struct {
+	entries []usb2snes.DirEntry
+	fetched time.Time
+}
+
+func NewFS(c *usb2snes.Conn, dirTTL time.Duration, cacheBytes int, exactSizes bool) *FS {
+	return &FS{
+		c:          c,
+		exactSizes: exactSizes,
+		dirTTL:     dirTTL,
+		dirs:       map[string]*dirCache{},
+		files:      newFileCache(cacheBytes),
+	}
+}
+
+func (f *FS) Root() (fs.Node, error) {
+	return &Dir{fs: f, path: "/"}, nil
+}
+
+// list returns the entries of dir without "." and "..", from cache if still fresh.
+// Must be called with mu held.
+func (f *FS) list(dir string) ([]usb2snes.DirEntry, error) {
+	if dc, ok := f.dirs[dir]; ok && time.Since(dc.fetched) < f.dirTTL {
+		return dc.entries, nil
+	}
+
+	all, err := f.c.List(dir)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+
+	entries := make([]usb2snes.DirEntry, 0, len(all))
+	for _, e := range all {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	f.dirs[dir] = &dirCache{entries: entries, fetched: time.Now()}
+	return entries, nil
+}
+
+// lookup finds name in dir. Must be called with mu held.
+func (f *FS) lookup(dir, name string) (usb2snes.DirEntry, error) {
+	entries, err := f.list(dir)
+	if err != nil {
+		return usb2snes.DirEntry{}, err
+	}
+	for _, e := range entries {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return usb2snes.DirEntry{}, fuse.ENOENT
+}
+
+// read returns the contents of the file at p, from cache if present. Must be called
+// with mu held.
+func (f *FS) read(p string) ([]byte, error) {
+	if data, ok := f.files.get(p); ok {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.c.GetFile(p, &buf, nil); err != nil {
+		return nil, toErrno(err)
+	}
+	data := buf.Bytes()
+	f.files.put(p, data)
+	return data, nil
+}
+
+// write uploads data to the file at p and updates the caches. Must be called with mu
+// held.
+func (f *FS) write(p string, data []byte) error {
+	if err := f.c.PutFile(p, bytes.NewReader(data), len(data), nil); err != nil {
+		f.files.remove(p)
+		delete(f.dirs, path.Dir(p))
+		return toErrno(err)
+	}
+	// data is usually a handle's buffer, which later writes change in place:
+	f.files.put(p, append([]byte(nil), data...))
+	delete(f.dirs, path.Dir(p))
+	return nil
+}
+
+// forget drops everything cached about p and everything below it. Must be called with
+// mu held.
+func (f *FS) forget(p string) {
+	f.files.removeTree(p)
+	for dir := range f.dirs {
+		if dir == p || isBelow(dir, p) {
+			delete(f.dirs, dir)
+		}
+	}
+	delete(f.dirs, path.Dir(p))
+}
+
+func isBelow(p, dir string) bool {
+	if dir == "/" {
+		return p != "/"
+	}
+	return len(p) > len(dir) && p[:len(dir)] == dir && p[len(dir)] == '/'
+}
+
+// toErrno maps a device error onto an errno for the kernel.
+func toErrno(err error) error {
+	var rerr *usb2snes.ResponseError
+	if errors.As(err, &rerr) {
+		// the firmware does not say why a command failed:
+		return fuse.Errno(syscall.EIO)
+	}
+	return err
+}
+
+type Dir struct {
+	fs   *FS
+	path string
+}
+
+var _ fs.Node = (*Dir)(nil)
+var _ fs.NodeStringLookuper = (*Dir)(nil)
+var _ fs.HandleReadDirAller = (*Dir)(nil)
+var _ fs.NodeMkdirer = (*Dir)(nil)
+var _ fs.NodeCreater = (*Dir)(nil)
+var _ fs.NodeRemover = (*Dir)(nil)
+var _ fs.NodeRenamer = (*Dir)(nil)
+
+func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0755
+	return nil
+}
+
+func (d *Dir) child(name string) string {
+	return path.Join(d.path, name)
+}
+
+func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	e, err := d.fs.lookup(d.path, name)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir() {
+		return &Dir{fs: d.fs, path: d.child(name)}, nil
+	}
+	return &File{fs: d.fs, path: d.child(name)}, nil
+}
+
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	entries, err := d.fs.list(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	dirents := make([]fuse.Dirent, 0, len(entries))
+	for _, e := range entries {
+		t := fuse.DT_File
+		if e.IsDir() {
+			t = fuse.DT_Dir
+		}
+		dirents = append(dirents, fuse.Dirent{Name: e.Name, Type: t})
+	}
+	return dirents, nil
+}
+
+func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	p := d.child(req.Name)
+	if err := d.fs.c.Mkdir(p); err != nil {
+		return nil, toErrno(err)
+	}
+	delete(d.fs.dirs, d.path)
+	return &Dir{fs: d.fs, path: p}, nil
+}
+
+// Create makes an empty file on the card right away so that it shows up in listings;
+// its contents are uploaded when the handle is closed.
+func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	p := d.child(req.Name)
+	if err := d.fs.write(p, nil); err != nil {
+		return nil, nil, err
+	}
+
+	file := &File{fs: d.fs, path: p}
+	return file, &FileHandle{file: file, writable: true, buf: []byte{}, loaded: true}, nil
+}
+
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	p := d.child(req.Name)
+	if err := d.fs.c.Remove(p); err != nil {
+		return toErrno(err)
+	}
+	d.fs.forget(p)
+	return nil
+}
+
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	nd, ok := newDir.(*Dir)
+	if !ok {
+		return fuse.Errno(syscall.EXDEV)
+	}
+
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+
+	from, to := d.child(req.OldName), nd.child(req.NewName)
+	if err := d.fs.c.Rename(from, to); err != nil {
+		return toErrno(err)
+	}
+	d.fs.forget(from)
+	d.fs.forget(to)
+	return nil
+}
+
+type File struct {
+	fs   *FS
+	path string
+}
+
+var _ fs.Node = (*File)(nil)
+var _ fs.NodeOpener = (*File)(nil)
+var _ fs.NodeSetattrer = (*File)(nil)
+
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	a.Mode = 0644
+	if data, ok := f.fs.files.get(f.path); ok {
+		a.Size = uint64(len(data))
+		return nil
+	}
+	if !f.fs.exactSizes {
+		return nil
+	}
+
+	data, err := f.fs.read(f.path)
+	if err != nil {
+		return err
+	}
+	a.Size = uint64(len(data))
+	return nil
+}
+
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !f.fs.exactSizes {
+		// the reported size may be a placeholder so bypass the page cache:
+		resp.Flags |= fuse.OpenDirectIO
+	}
+
+	h := &FileHandle{file: f, writable: !req.Flags.IsReadOnly()}
+	if h.writable && req.Flags&fuse.OpenTruncate != 0 {
+		h.buf, h.loaded, h.dirty = []byte{}, true, true
+	}
+	return h, nil
+}
+
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if !req.Valid.Size() {
+		return nil
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	data, err := f.fs.read(f.path)
+	if err != nil {
+		return err
+	}
+	data = resize(append([]byte(nil), data...), int(req.Size))
+	if err = f.fs.write(f.path, data); err != nil {
+		return err
+	}
+	resp.Attr.Mode = 0644
+	resp.Attr.Size = req.Size
+	return nil
+}
+
+// FileHandle buffers a file's contents; writes are uploaded in one PUT when the handle
+// is flushed.
+type FileHandle struct {
+	file     *File
+	writable bool
+
+	buf    []byte
+	loaded bool
+	dirty  bool
+}
+
+var _ fs.HandleReader = (*FileHandle)(nil)
+var _ fs.HandleWriter = (*FileHandle)(nil)
+var _ fs.HandleFlusher = (*FileHandle)(nil)
+var _ fs.HandleReleaser = (*FileHandle)(nil)
+
+// load fills buf from the card unless it already holds the file. Must be called with
+// mu held.
+func (h *FileHandle) load() error {
+	if h.loaded {
+		return nil
+	}
+	data, err := h.file.fs.read(h.file.path)
+	if err != nil {
+		return err
+	}
+	h.buf = append([]byte(nil), data...)
+	h.loaded = true
+	return nil
+}
+
+func (h *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.file.fs.mu.Lock()
+	defer h.file.fs.mu.Unlock()
+
+	if err := h.load(); err != nil {
+		return err
+	}
+
+	off := int(req.Offset)
+	if off >= len(h.buf) {
+		resp.Data = nil
+		return nil
+	}
+	end := off + req.Size
+	if end > len(h.buf) {
+		end = len(h.buf)
+	}
+	resp.Data = h.buf[off:end]
+	return nil
+}
+
+func (h *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	h.file.fs.mu.Lock()
+	defer h.file.fs.mu.Unlock()
+
+	if err := h.load(); err != nil {
+		return err
+	}
+
+	off := int(req.Offset)
+	if end := off + len(req.Data); end > len(h.buf) {
+		h.buf = resize(h.buf, end)
+	}
+	copy(h.buf[off:], req.Data)
+	h.dirty = true
+	resp.Size = len(req.Data)
+	return nil
+}
+
+func (h *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.file.fs.mu.Lock()
+	defer h.file.fs.mu.Unlock()
+
+	if !h.dirty {
+		return nil
+	}
+	if err := h.file.fs.write(h.file.path, h.buf); err != nil {
+		return err
+	}
+	h.dirty = false
+	return nil
+}
+
+func (h *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.Flush(ctx, nil)
+}
+
+// resize returns data truncated or zero-extended to n bytes.
+func resize(data []byte, n int) []byte {
+	if n <= len(data) {
+		return data[:n]
+	}
+	return append(data, make([]byte, n-len(data))...)
+}
@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+
+	"sertest/usb2snes"
+)
+
+func TestFileCache(t *testing.T) {
+	c := newFileCache(10)
+	c.put("/a", []byte("aaaa"))
+	c.put("/b", []byte("bbbb"))
+	c.get("/a")
+	// /b is now the least recently used:
+	c.put("/c", []byte("cccc"))
+	if _, ok := c.get("/b"); ok {
+		t.Error("/b not evicted")
+	}
+	for _, p := range []string{"/a", "/c"} {
+		if _, ok := c.get(p); !ok {
+			t.Errorf("%s evicted", p)
+		}
+	}
+	if c.size != 8 {
+		t.Errorf("size = %d, want 8", c.size)
+	}
+
+	// too big to cache, and the stale entry goes:
+	c.put("/a", make([]byte, 11))
+	if _, ok := c.get("/a"); ok {
+		t.Error("oversized /a cached")
+	}
+	if c.size != 4 {
+		t.Errorf("size = %d, want 4", c.size)
+	}
+
+	c.put("/dir/x", []byte("x"))
+	c.put("/dir/sub/y", []byte("y"))
+	c.put("/dirt", []byte("z"))
+	c.removeTree("/dir")
+	for p, want := range map[string]bool{"/dir/x": false, "/dir/sub/y": false, "/dirt": true, "/c": true} {
+		if _, ok := c.get(p); ok != want {
+			t.Errorf("%s cached = %v, want %v", p, ok, want)
+		}
+	}
+}
+
+func newTestFS(t *testing.T, dirTTL time.Duration) (*usb2snes.MemDevice, *Dir) {
+	t.Helper()
+	d := usb2snes.NewMemDevice()
+	d.Files["/a.txt"] = []byte("one")
+	f := NewFS(usb2snes.NewConn("mem", d), dirTTL, 1<<20, false)
+	root, err := f.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, root.(*Dir)
+}
+
+// readAll opens the file at name in dir and reads it through a new handle.
+func readAll(t *testing.T, dir *Dir, name string) string {
+	t.Helper()
+	ctx := context.Background()
+	n, err := dir.Lookup(ctx, name)
+	if err != nil {
+		t.Fatalf("Lookup(%s): %v", name, err)
+	}
+	h, err := n.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatalf("Open(%s): %v", name, err)
+	}
+	var resp fuse.ReadResponse
+	if err = h.(*FileHandle).Read(ctx, &fuse.ReadRequest{Size: 4096}, &resp); err != nil {
+		t.Fatalf("Read(%s): %v", name, err)
+	}
+	return string(resp.Data)
+}
+
+func TestFSReadCache(t *testing.T) {
+	d, root := newTestFS(t, time.Hour)
+	ctx := context.Background()
+
+	if got := readAll(t, root, "a.txt"); got != "one" {
+		t.Fatalf("read = %q, want %q", got, "one")
+	}
+	// changed behind the mount's back, so the cached copy is still served:
+	d.Files["/a.txt"] = []byte("two")
+	if got := readAll(t, root, "a.txt"); got != "one" {
+		t.Errorf("cached read = %q, want %q", got, "one")
+	}
+
+	if err := root.Rename(ctx, &fuse.RenameRequest{OldName: "a.txt", NewName: "b.txt"}, root); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := root.Lookup(ctx, "a.txt"); err != fuse.ENOENT {
+		t.Errorf("Lookup(a.txt) after rename = %v, want ENOENT", err)
+	}
+	if got := readAll(t, root, "b.txt"); got != "two" {
+		t.Errorf("read after rename = %q, want %q", got, "two")
+	}
+
+	if err := root.Remove(ctx, &fuse.RemoveRequest{Name: "b.txt"}); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := root.Lookup(ctx, "b.txt"); err != fuse.ENOENT {
+		t.Errorf("Lookup(b.txt) after remove = %v, want ENOENT", err)
+	}
+}
+
+func TestFSFlush(t *testing.T) {
+	d, root := newTestFS(t, time.Hour)
+	ctx := context.Background()
+
+	_, h, err := root.Create(ctx, &fuse.CreateRequest{Name: "new.txt"}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	fh := h.(*FileHandle)
+	if got, ok := d.Files["/new.txt"]; !ok || len(got) != 0 {
+		t.Fatalf("after Create the card has %q, %v; want an empty file", got, ok)
+	}
+
+	write := func(off int64, data string) {
+		t.Helper()
+		if err := fh.Write(ctx, &fuse.WriteRequest{Offset: off, Data: []byte(data)}, &fuse.WriteResponse{}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	write(0, "hello")
+	if got := string(d.Files["/new.txt"]); got != "" {
+		t.Errorf("uploaded %q before Flush", got)
+	}
+	if err = fh.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := string(d.Files["/new.txt"]); got != "hello" {
+		t.Errorf("after Flush the card has %q, want %q", got, "hello")
+	}
+
+	// a clean handle uploads nothing:
+	d.Files["/new.txt"] = []byte("other")
+	if err = fh.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := string(d.Files["/new.txt"]); got != "other" {
+		t.Errorf("clean Flush uploaded %q", got)
+	}
+
+	write(5, ", world")
+	if err = fh.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if got := string(d.Files["/new.txt"]); got != "hello, world" {
+		t.Errorf("after Release the card has %q, want %q", got, "hello, world")
+	}
+	// the cache holds a copy, not the handle's buffer:
+	fh.buf[0] = 'J'
+	if got := readAll(t, root, "new.txt"); got != "hello, world" {
+		t.Errorf("read after Release = %q, want %q", got, "hello, world")
+	}
+}
+
+func TestFSDirTTL(t *testing.T) {
+	for _, tc := range []struct {
+		ttl  time.Duration
+		seen bool
+	}{
+		{0, true},
+		{time.Hour, false},
+	} {
+		d, root := newTestFS(t, tc.ttl)
+		ctx := context.Background()
+
+		if _, err := root.Lookup(ctx, "a.txt"); err != nil {
+			t.Fatalf("ttl %v: Lookup(a.txt): %v", tc.ttl, err)
+		}
+		d.Files["/c.txt"] = []byte("c")
+		_, err := root.Lookup(ctx, "c.txt")
+		if seen := err == nil; seen != tc.seen {
+			t.Errorf("ttl %v: c.txt seen = %v, want %v", tc.ttl, seen, tc.seen)
+		}
+
+		// changes through the mount drop the listing whatever the TTL:
+		if _, err = root.Mkdir(ctx, &fuse.MkdirRequest{Name: "sub"}); err != nil {
+			t.Fatalf("ttl %v: Mkdir: %v", tc.ttl, err)
+		}
+		n, err := root.Lookup(ctx, "sub")
+		if err != nil {
+			t.Fatalf("ttl %v: Lookup(sub): %v", tc.ttl, err)
+		}
+		if _, ok := n.(*Dir); !ok {
+			t.Errorf("ttl %v: sub is a %T, want *Dir", tc.ttl, n)
+		}
+		if _, err = root.Lookup(ctx, "c.txt"); err != nil {
+			t.Errorf("ttl %v: Lookup(c.txt) after Mkdir: %v", tc.ttl, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"sertest/usb2snes"
+)
+
+// Exit codes:
+const (
+	exitUsage = 2
+)
+
+func main() {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	fake := flag.String("fake", "", "serve an in-memory card preloaded from this local directory instead of a device")
+	dirTTL := flag.Duration("dir-ttl", 5*time.Second, "how long directory listings stay cached (0 = not cached)")
+	cacheMB := flag.Int("cache-mb", 64, "size of the file contents cache in MiB")
+	exactSizes := flag.Bool("exact-sizes", false, "download files on stat to report their real sizes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fxpakmount [flags] <mountpoint>\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	mountpoint := flag.Arg(0)
+
+	var c *usb2snes.Conn
+	if *fake != "" {
+		d := usb2snes.NewMemDevice()
+		if err := loadFake(d, *fake); err != nil {
+			log.Fatal(err)
+		}
+		c = usb2snes.NewConn("fake", d)
+		log.Printf("serving in-memory card loaded from '%s'\n", *fake)
+	} else {
+		var err error
+		if c, err = usb2snes.OpenDevice(*portName); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: FX Pak Pro opened\n", c.Name)
+	}
+	defer c.Close()
+
+	mc, err := fuse.Mount(
+		mountpoint,
+		fuse.FSName("fxpak"),
+		fuse.Subtype("fxpakfs"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mc.Close()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Printf("unmounting '%s'\n", mountpoint)
+		if err := fuse.Unmount(mountpoint); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	log.Printf("mounted on '%s'\n", mountpoint)
+	if err = fs.Serve(mc, NewFS(c, *dirTTL, *cacheMB<<20, *exactSizes)); err != nil {
+		log.Fatal(err)
+	}
+
+	<-mc.Ready
+	if err = mc.MountError; err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadFake copies the local directory tree at dir into the in-memory card.
+func loadFake(d *usb2snes.MemDevice, dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join("/", filepath.ToSlash(rel))
+
+		if fi.IsDir() {
+			d.Dirs[name] = true
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		d.Files[name] = data
+		return nil
+	})
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Exit codes:
+const (
+	exitError = 1
+)
+
+func main() {
+	fmt.Fprintln(os.Stderr, "fxpakmount: FUSE mounts are only supported on Linux")
+	os.Exit(exitError)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sertest/usb2snes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	name  string
+	args  string
+	about string
+	run   func(c *usb2snes.Conn, args []string) error
+}
+
+var commands = []command{
+	{"upload", "[-rom path] <file.msu | file-N.pcm>...", "upload MSU-1 data and audio tracks next to a ROM on the card", cmdUPLOAD},
+	{"ls", "[-rom path]", "list the MSU-1 files next to a ROM on the card", cmdLS},
+	{"verify", "<file.msu>", "compare the running ROM's MSU-1 data against a local file", cmdVERIFY},
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: fxpakmsu [-port name] <command> [args]\n\n")
+	fmt.Fprintf(o, "-rom defaults to the running ROM.\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(o, "  %-7s %s\n          %s\n", cmd.name, cmd.args, cmd.about)
+	}
+	fmt.Fprintf(o, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() < 1 {
+		usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	err = cmd.run(c, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: fxpakmsu %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
+
+// romBase returns the ROM's path on the card without its extension; MSU-1 files are
+// named by appending ".msu" or "-N.pcm" to it.
+func romBase(c *usb2snes.Conn, rom string) (string, error) {
+	if rom == "" {
+		info, err := c.Info()
+		if err != nil {
+			return "", err
+		}
+		rom = info.ROM
+		log.Printf("%s: running '%s'\n", c.Name, rom)
+	}
+	if rom == "" || usb2snes.IsMenu(rom) {
+		return "", fmt.Errorf("no ROM running; pass -rom")
+	}
+	rom = path.Clean("/" + rom)
+	return strings.TrimSuffix(rom, path.Ext(rom)), nil
+}
+
+var trackRe = regexp.MustCompile(`-(\d+)\.pcm$`)
+
+// remoteName maps a local MSU-1 file onto its name next to the ROM.
+func remoteName(base string, local string) (string, error) {
+	name := strings.ToLower(filepath.Base(local))
+	if filepath.Ext(name) == ".msu" {
+		return base + ".msu", nil
+	}
+	m := trackRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", fmt.Errorf("%s: not an .msu file or a -N.pcm track", local)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n > 65535 {
+		return "", fmt.Errorf("%s: bad track number", local)
+	}
+	return fmt.Sprintf("%s-%d.pcm", base, n), nil
+}
+
+// checkPCM verifies the "MSU1" signature at the start of an audio track.
+func checkPCM(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var sig [8]byte
+	if _, err := io.ReadFull(f, sig[:]); err != nil {
+		return fmt.Errorf("%s: too short for an MSU-1 track", name)
+	}
+	if string(sig[:4]) != "MSU1" {
+		return fmt.Errorf("%s: missing MSU1 signature", name)
+	}
+	return nil
+}
+
+func cmdUPLOAD(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	rom := fs.String("rom", "", "path of the ROM on the card")
+	if fs.Parse(args) != nil || fs.NArg() < 1 {
+		return errUsage
+	}
+
+	base, err := romBase(c, *rom)
+	if err != nil {
+		return err
+	}
+
+	// check everything before sending anything:
+	remotes := make([]string, fs.NArg())
+	for i, local := range fs.Args() {
+		if remotes[i], err = remoteName(base, local); err != nil {
+			return err
+		}
+		if strings.HasSuffix(remotes[i], ".pcm") {
+			if err = checkPCM(local); err != nil {
+				return err
+			}
+		}
+	}
+
+	var total int64
+	start := time.Now()
+	for i, local := range fs.Args() {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		log.Printf("%s: upload '%s' to '%s'\n", c.Name, local, remotes[i])
+		err = c.PutFile(remotes[i], f, int(fi.Size()), newProgress(remotes[i]))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("put %s: %w", remotes[i], err)
+		}
+		total += fi.Size()
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("%s: %d bytes in %v (%s)\n", c.Name, total, elapsed.Round(time.Millisecond), rate(total, elapsed))
+	return nil
+}
+
+func cmdLS(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	rom := fs.String("rom", "", "path of the ROM on the card")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	base, err := romBase(c, *rom)
+	if err != nil {
+		return err
+	}
+
+	dir, prefix := path.Split(base)
+	entries, err := c.List(dir)
+	if err != nil {
+		return fmt.Errorf("ls %s: %w", dir, err)
+	}
+
+	var msu string
+	tracks := map[int]string{}
+	for _, e := range entries {
+		// the card's FAT names are case-insensitive; compare the name's own first bytes
+		// so the rest is cut where the match ends:
+		if e.IsDir() || len(e.Name) < len(prefix) || !strings.EqualFold(e.Name[:len(prefix)], prefix) {
+			continue
+		}
+		rest := strings.ToLower(e.Name[len(prefix):])
+		if rest == ".msu" {
+			msu = e.Name
+		} else if m := trackRe.FindStringSubmatch(rest); m != nil && rest == m[0] {
+			n, _ := strconv.Atoi(m[1])
+			tracks[n] = e.Name
+		}
+	}
+
+	if msu == "" {
+		fmt.Printf("no %s.msu data file\n", prefix)
+	} else {
+		fmt.Printf("data:   %s\n", msu)
+	}
+
+	nums := make([]int, 0, len(tracks))
+	for n := range tracks {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	for _, n := range nums {
+		fmt.Printf("track %3d: %s\n", n, tracks[n])
+	}
+	return nil
+}
+
+func cmdVERIFY(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	info, err := c.Info()
+	if err != nil {
+		return err
+	}
+	if info.Features&usb2snes.FeatMSU1 == 0 {
+		log.Printf("%s: warning: running ROM '%s' does not report MSU-1 support\n", c.Name, info.ROM)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	// read the MSU address space back in chunks so progress can be shown:
+	const chunkSize = 0x10000
+	want := make([]byte, chunkSize)
+	progress := newProgress("SpaceMSU")
+	start := time.Now()
+	for off := int64(0); off < size; off += chunkSize {
+		n := size - off
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if _, err = io.ReadFull(f, want[:n]); err != nil {
+			return err
+		}
+		got, err := c.Get(usb2snes.SpaceMSU, uint32(off), uint32(n))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want[:n]) {
+			for i := range got {
+				if got[i] != want[i] {
+					fmt.Fprintln(os.Stderr)
+					return fmt.Errorf("verify: mismatch at offset $%x: file has $%02x, MSU space has $%02x", off+int64(i), want[i], got[i])
+				}
+			}
+		}
+		progress(int(off+n), int(size))
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("%s: %d bytes verified in %v (%s)\n", c.Name, size, elapsed.Round(time.Millisecond), rate(size, elapsed))
+	return nil
+}
+
+// newProgress returns a usb2snes.Progress that redraws a status line with the transfer
+// rate on stderr.
+func newProgress(name string) usb2snes.Progress {
+	start := time.Now()
+	last := time.Time{}
+	return func(done, total int) {
+		now := time.Now()
+		if done < total && now.Sub(last) < 100*time.Millisecond {
+			return
+		}
+		last = now
+
+		pct := 100
+		if total > 0 {
+			pct = done * 100 / total
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %d / %d bytes (%3d%%) %s   ", name, done, total, pct, rate(int64(done), now.Sub(start)))
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func rate(n int64, d time.Duration) string {
+	if d <= 0 {
+		return "- KiB/s"
+	}
+	return fmt.Sprintf("%.1f KiB/s", float64(n)/1024/d.Seconds())
+}
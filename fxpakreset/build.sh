@@ -0,0 +1,2 @@
+#!/bin/bash
+GOOS=windows GOARCH=amd64 go build -ldflags="-s -w"
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"sertest/usb2snes"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for the menu or the device to come back")
+	skipReset := flag.Bool("skip-reset", false, "send FlagSKIPRESET with the command")
+	onlyReset := flag.Bool("only-reset", false, "send FlagONLYRESET with the command")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fxpakreset [flags] reset|menu|power\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  reset  reset the running game\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  menu   reset to the FX Pak Pro menu\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  power  power cycle the cart\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return exitUsage
+	}
+
+	flags := usb2snes.FlagNONE
+	if *skipReset {
+		flags |= usb2snes.FlagSKIPRESET
+	}
+	if *onlyReset {
+		flags |= usb2snes.FlagONLYRESET
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	start := time.Now()
+	switch flag.Arg(0) {
+	case "reset":
+		// the device keeps answering and reporting the same ROM through a reset so
+		// there is nothing to wait for:
+		log.Printf("%s: reset\n", c.Name)
+		if err = c.Reset(flags); err != nil {
+			log.Println(err)
+			return exitError
+		}
+		return exitOK
+	case "menu":
+		log.Printf("%s: menu reset\n", c.Name)
+		if err = c.MenuReset(flags); err == nil {
+			err = c.WaitForROM(usb2snes.MenuROM, *timeout)
+		}
+	case "power":
+		log.Printf("%s: power cycle\n", c.Name)
+		if err = c.PowerCycle(flags); err == nil {
+			err = c.Reconnect(*timeout)
+		}
+	default:
+		flag.Usage()
+		return exitUsage
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+
+	log.Printf("%s: device back after %v\n", c.Name, time.Since(start).Round(time.Millisecond))
+	return exitOK
+}
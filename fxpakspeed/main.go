@@ -4,25 +4,90 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
-	"github.com/aybabtme/uniplot/histogram"
 	"go.bug.st/serial"
 	"go.bug.st/serial/enumerator"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
+	"gopkg.in/yaml.v2"
 	"io"
 	"log"
-	"math"
 	"os"
 	"runtime/debug"
+	"sertest/bench"
+	"sertest/snestime"
+	"sertest/stats"
+	"sertest/usb2snes"
 	"strings"
 	"time"
 )
 
+// Exit codes:
+const (
+	exitError = 1
+	exitUsage = 2
+)
+
+// outlierFilter separates the outliers from each test's summary.
+var outlierFilter stats.OutlierFilter
+
+// region is the video standard whose frames latencies are also reported in.
+var region snestime.Region
+
 func main() {
-	doVGET := flag.Bool("vget", false, "run VGET tests")
-	doGET := flag.Bool("get", false, "run GET tests")
+	doVGET := flag.Bool("vget", false, "run the built-in VGET tests")
+	doGET := flag.Bool("get", false, "run the built-in GET tests")
+	specFile := flag.String("spec", "", "run the benchmark suite in this YAML file instead of the built-in tests")
+	dumpSpec := flag.Bool("dump-spec", false, "print the selected built-in tests as a spec file and exit")
+	iterations := flag.Int("iterations", defaultIterations, "timed iterations per size for the built-in tests")
+	warmup := flag.Int("warmup", 0, "untimed iterations per size for the built-in tests")
+	delay := flag.Duration("delay", 0, "pause between commands for the built-in tests")
+	outliers := flag.String("outliers", "iqr", "outlier filter: none, iqr[:k], mad[:k] or fixed:duration")
+	regionName := flag.String("region", "ntsc", "report latencies in frames of this video standard: ntsc or pal")
 	flag.Parse()
 
+	var err error
+	if outlierFilter, err = stats.ParseOutlierFilter(*outliers); err != nil {
+		log.Println(err)
+		os.Exit(exitUsage)
+	}
+	if region, err = snestime.ParseRegion(*regionName); err != nil {
+		log.Println(err)
+		os.Exit(exitUsage)
+	}
+
+	var spec *benchSpec
+	if *specFile != "" {
+		if spec, err = loadSpec(*specFile); err != nil {
+			log.Println(err)
+			os.Exit(exitUsage)
+		}
+	} else {
+		addr := defaultAddr
+		spec = builtinSpec(*doGET, *doVGET, testDefaults{
+			Addr:       &addr,
+			Iterations: *iterations,
+			Warmup:     warmup,
+			Delay:      delay,
+		})
+		if len(spec.Tests) == 0 {
+			log.Println("nothing to do; pass -get, -vget or -spec")
+			os.Exit(exitUsage)
+		}
+		if err := spec.resolve(); err != nil {
+			log.Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if *dumpSpec {
+		out, err := yaml.Marshal(spec)
+		if err != nil {
+			log.Println(err)
+			os.Exit(exitError)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
 	timestamp := strings.ReplaceAll(time.Now().UTC().Format("2006-01-02T15-04-05.000000"), ".", "-")
 	logfilename := timestamp + ".txt"
@@ -34,9 +99,21 @@ func main() {
 		log.Println(err)
 		return
 	}
+	run := bench.NewRun("fxpakspeed")
+	run.Outliers = outlierFilter
+	run.Region = region
 	defer (func() {
 		logfile.Close()
 		fmt.Printf("Output written to '%s'\n", logfilename)
+		if len(run.Tests) == 0 {
+			return
+		}
+		names, err := run.Save(timestamp)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Results written to '%s'\n", strings.Join(names, "', '"))
 	})()
 	log.SetOutput(io.MultiWriter(logfile, os.Stdout))
 
@@ -61,6 +138,9 @@ func main() {
 		if port.SerialNumber == "DEMO00000000" {
 			portName = port.Name
 			log.Printf("%s: FX Pak Pro found\n", portName)
+			run.Device.Port = port.Name
+			run.Device.Serial = port.SerialNumber
+			run.Device.VID, run.Device.PID = port.VID, port.PID
 			break
 		}
 	}
@@ -129,201 +209,196 @@ func main() {
 	// Disable GC
 	debug.SetGCPercent(-1)
 
-	if *doVGET {
-		writeVGETTest(f)
+	c := usb2snes.NewConn(portName, f)
+	if info, err := c.Info(); err != nil {
+		log.Printf("%s: info: %v\n", portName, err)
+	} else {
+		log.Printf("%s: firmware %s\n", portName, info.Version)
+		run.SetInfo(info)
 	}
 
-	if *doGET {
-		writeGETTest(f)
+	for i := range spec.Tests {
+		runTest(f, &spec.Tests[i], run)
 	}
 
 	//writeTestSpinLoop(f)
 }
 
-func writeGETTest(f serial.Port) {
+// runTest runs every size of t, logging the command and a histogram of its latencies
+// and adding the results to run.
+func runTest(f serial.Port, t *testSpec, run *bench.Run) {
 	p := message.NewPrinter(language.AmericanEnglish)
 
-	var tmp [0x2200]byte
-
-	// Perform some timing tests:
-	gatherSizes := [...]uint32{
-		0x01 * 8, 0x02 * 8, 0x04 * 8, 0x08 * 8, 0x10 * 8, 0x20 * 8, 0x40 * 8, 0x80 * 8, 0xFF * 8,
-		0x1000, 0x2000}
-	for _, size := range gatherSizes {
-		var sb [512]byte
-		sb[0] = byte('U')
-		sb[1] = byte('S')
-		sb[2] = byte('B')
-		sb[3] = byte('A')
-		sb[4] = byte(OpGET)
-		sb[5] = byte(SpaceSNES)
-		sb[6] = byte(FlagNONE)
-
-		addr := uint32(0xF50000)
-		// size:
-		sb[252] = byte((size >> 24) & 0xFF)
-		sb[253] = byte((size >> 16) & 0xFF)
-		sb[254] = byte((size >> 8) & 0xFF)
-		sb[255] = byte((size >> 0) & 0xFF)
-		// addr:
-		sb[256] = byte((addr >> 24) & 0xFF)
-		sb[257] = byte((addr >> 16) & 0xFF)
-		sb[258] = byte((addr >> 8) & 0xFF)
-		sb[259] = byte((addr >> 0) & 0xFF)
-
-		expectedBytes := int(size)
-		expectedPaddedBytes := (expectedBytes / 512) * 512
-		if expectedBytes&511 != 0 {
-			expectedPaddedBytes += 512
+	log.Printf("%s\n", t)
+	for _, size := range t.Sizes {
+		var err error
+		switch specOps[t.Op] {
+		case usb2snes.OpGET:
+			err = runGET(f, t, size, newResult(run, t, size), p)
+		case usb2snes.OpPUT:
+			err = runPUT(f, t, size, newResult(run, t, size), p)
+		case usb2snes.OpVGET:
+			err = runVGET(f, t, size, newResult(run, t, size), p)
 		}
+		if err != nil {
+			log.Printf("%s: size %d: %v\n", t.Name, size, err)
+		}
+	}
+}
 
-		log.Printf("GET command:\n%s\n", hex.Dump(sb[:]))
-
-		const iterations = 500
-		times := [iterations]float64{}
+// newResult adds the results of one size of t to run.
+func newResult(run *bench.Run, t *testSpec, size uint32) *bench.Test {
+	params := map[string]interface{}{
+		"op":       t.Op,
+		"space":    t.Space,
+		"addr":     *t.Addr,
+		"size":     size,
+		"warmup":   *t.Warmup,
+		"delay_ns": t.Delay.Nanoseconds(),
+	}
+	name := fmt.Sprintf("%s/%d", t.Name, size)
+	bytes := int(size)
+	if t.Op == "vget" {
+		params["ranges"] = t.Ranges
+		name = fmt.Sprintf("%s/%dx%d", t.Name, t.Ranges, size)
+		bytes *= t.Ranges
+	}
+	return run.NewTest(name, bytes, t.Iterations, params)
+}
 
-		start := time.Now()
-		lastWrite := start
-		for i := 0; i < iterations; i++ {
-			// write:
-			lastWrite = time.Now()
-			// log.Printf("write(GET)\n")
-			n, err := f.Write(sb[:])
-			if err != nil {
-				log.Printf("write(): %v\n", err)
-				continue
-			}
-			// log.Printf("write(): wrote %d bytes\n", n)
-			if n != len(sb[:]) {
-				log.Printf("write(): expected to write 512 bytes but wrote %d\n", n)
+// measure runs fn t.Warmup times untimed and then t.Iterations times, pausing t.Delay
+// between commands, records the timed runs in rt and reports them. If prepare is not nil
+// it runs untimed before every call of fn.
+func measure(t *testSpec, rt *bench.Test, p *message.Printer, prepare, fn func() error) {
+	for i := 0; i < *t.Warmup; i++ {
+		if prepare != nil {
+			if err := prepare(); err != nil {
+				log.Printf("warmup: %v\n", err)
 				continue
 			}
+		}
+		if err := fn(); err != nil {
+			log.Printf("warmup: %v\n", err)
+		}
+		if *t.Delay > 0 {
+			time.Sleep(*t.Delay)
+		}
+	}
 
-			// response:
-			var rsp [512]byte
-			err = readChunk(f, rsp[:])
-			if err != nil {
+	for i := 0; i < t.Iterations; i++ {
+		if prepare != nil {
+			if err := prepare(); err != nil {
 				log.Println(err)
+				rt.Fail(i, err)
 				continue
 			}
-
-			// read:
-			err = readChunk(f, tmp[:expectedPaddedBytes])
-			if err != nil {
-				log.Printf("readChunk(): %v\n", err)
-				continue
-			}
-			//log.Printf("GET response:\n%s\n", hex.Dump(data))
-			//log.Printf("[$10] = $%02x; [$1A] = $%02x\n", data[0x10], data[0x1A])
-
-			times[i] = float64(time.Now().Sub(lastWrite).Nanoseconds())
 		}
+		start := time.Now()
+		if err := fn(); err != nil {
+			log.Println(err)
+			rt.Fail(i, err)
+			continue
+		}
+		rt.Record(i, time.Since(start))
+		if *t.Delay > 0 {
+			time.Sleep(*t.Delay)
+		}
+	}
 
-		//end := time.Now()
-		//log.Printf("%#v ns total; %#v ns avg\n", end.Sub(start).Nanoseconds(), end.Sub(start).Nanoseconds() / iterations)
-
-		reportHistograms(times[:], p)
+	if err := bench.Report(log.Writer(), rt, outlierFilter, region, p); err != nil {
+		log.Println(err)
 	}
 }
 
-func writeVGETTest(f serial.Port) {
-	p := message.NewPrinter(language.AmericanEnglish)
+func runGET(f serial.Port, t *testSpec, size uint32, rt *bench.Test, p *message.Printer) error {
+	sb := usb2snes.MakeGET(specSpaces[t.Space], *t.Addr, size)
+	log.Printf("GET command:\n%s\n", hex.Dump(sb))
 
-	var tmp [0x2200]byte
-
-	// Perform some timing tests:
-	gatherSizes := [...]uint8{0x01, 0x02, 0x04, 0x08, 0x10, 0x20, 0x40, 0x80, 0xFF}
-	for _, size := range gatherSizes {
-		var sb [64]byte
-		sb[0] = byte('U')
-		sb[1] = byte('S')
-		sb[2] = byte('B')
-		sb[3] = byte('A')
-		sb[4] = byte(OpVGET)
-		sb[5] = byte(SpaceSNES)
-		sb[6] = byte(FlagDATA64B | FlagNORESP)
-
-		addr := uint32(0xF50000)
-		expectedBytes := 0
-		for i := 0; i < 8; i++ {
-			sb[32+i*4] = byte(size)
-			sb[33+i*4] = byte((addr >> 16) & 0xFF)
-			sb[34+i*4] = byte((addr >> 8) & 0xFF)
-			sb[35+i*4] = byte((addr >> 0) & 0xFF)
-			addr += uint32(size)
-			expectedBytes += int(size)
+	tmp := make([]byte, usb2snes.PaddedSize(int(size), usb2snes.BlockSize))
+	measure(t, rt, p, nil, func() error {
+		if err := writeChunk(f, sb); err != nil {
+			return err
 		}
-
-		expectedPaddedBytes := (expectedBytes / 64) * 64
-		if expectedBytes&0x3F != 0 {
-			expectedPaddedBytes += 64
+		// response:
+		var rsp [512]byte
+		if err := readChunk(f, rsp[:]); err != nil {
+			return err
 		}
+		// read:
+		return readChunk(f, tmp)
+	})
+	return nil
+}
 
-		log.Printf("VGET command:\n%s\n", hex.Dump(sb[:]))
-
-		const iterations = 500
-		times := [iterations]float64{}
-
-		start := time.Now()
-		lastWrite := start
-		for i := 0; i < iterations; i++ {
-			// write:
-			lastWrite = time.Now()
-			// log.Printf("write(VGET)\n")
-			n, err := f.Write(sb[:])
-			if err != nil {
-				log.Printf("write(): %v\n", err)
-				continue
-			}
-			// log.Printf("write(): wrote %d bytes\n", n)
-			if n != len(sb[:]) {
-				log.Printf("write(): expected to write 64 bytes but wrote %d\n", n)
-				continue
-			}
-
-			// read:
-			err = readChunk(f, tmp[:expectedPaddedBytes])
-			if err != nil {
-				log.Printf("readChunk(): %v\n", err)
-				continue
-			}
-			//log.Printf("VGET response:\n%s\n", hex.Dump(data))
-			//log.Printf("[$10] = $%02x; [$1A] = $%02x\n", data[0x10], data[0x1A])
-
-			times[i] = float64(time.Now().Sub(lastWrite).Nanoseconds())
+// runPUT reads what is at the address right before each PUT, untimed, and writes it
+// back, so the benchmark only undoes a write the game makes in between.
+func runPUT(f serial.Port, t *testSpec, size uint32, rt *bench.Test, p *message.Printer) error {
+	get := usb2snes.MakeGET(specSpaces[t.Space], *t.Addr, size)
+	put := usb2snes.MakePUT(specSpaces[t.Space], *t.Addr, size)
+	log.Printf("PUT command:\n%s\n", hex.Dump(put))
+
+	var rsp [512]byte
+	data := make([]byte, usb2snes.PaddedSize(int(size), usb2snes.BlockSize))
+	read := func() error {
+		if err := writeChunk(f, get); err != nil {
+			return err
 		}
-
-		//end := time.Now()
-		//log.Printf("%#v ns total; %#v ns avg\n", end.Sub(start).Nanoseconds(), end.Sub(start).Nanoseconds() / iterations)
-
-		reportHistograms(times[:], p)
+		if err := readChunk(f, rsp[:]); err != nil {
+			return err
+		}
+		return readChunk(f, data)
 	}
-}
-
-func reportHistograms(times []float64, p *message.Printer) {
-	cleaned, outliers := cleanData(times[:])
 
-	hist := histogram.Hist(10, cleaned)
-	err := histogram.Fprintf(log.Writer(), hist, histogram.Linear(40), func(v float64) string {
-		return p.Sprintf("% 11dns", time.Duration(v).Nanoseconds())
-		//return fmt.Sprintf("% 10dns", time.Duration(v).Nanoseconds())
-		//return time.Duration(v).String()
+	measure(t, rt, p, read, func() error {
+		if err := writeChunk(f, put); err != nil {
+			return err
+		}
+		// response:
+		if err := readChunk(f, rsp[:]); err != nil {
+			return err
+		}
+		// write:
+		return writeChunk(f, data)
 	})
+	return nil
+}
+
+// runVGET reads t.Ranges consecutive ranges of size bytes in one command.
+func runVGET(f serial.Port, t *testSpec, size uint32, rt *bench.Test, p *message.Printer) error {
+	ranges := make([]usb2snes.VGETRange, t.Ranges)
+	addr := *t.Addr
+	expectedBytes := 0
+	for i := range ranges {
+		ranges[i] = usb2snes.VGETRange{Addr: addr, Size: int(size)}
+		addr += size
+		expectedBytes += int(size)
+	}
+	sb, err := usb2snes.MakeVGETRanges(ranges)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
+	log.Printf("VGET command:\n%s\n", hex.Dump(sb))
 
-	fmt.Println()
-
-	hist = histogram.Hist(10, outliers)
-	err = histogram.Fprintf(log.Writer(), hist, histogram.Linear(40), func(v float64) string {
-		return p.Sprintf("% 11dns", time.Duration(v).Nanoseconds())
-		//return fmt.Sprintf("% 10dns", time.Duration(v).Nanoseconds())
-		//return time.Duration(v).String()
+	tmp := make([]byte, usb2snes.PaddedSize(expectedBytes, usb2snes.Block64Size))
+	measure(t, rt, p, nil, func() error {
+		if err := writeChunk(f, sb); err != nil {
+			return err
+		}
+		// read:
+		return readChunk(f, tmp)
 	})
+	return nil
+}
+
+func writeChunk(f serial.Port, chunk []byte) error {
+	n, err := f.Write(chunk)
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("write(): %w", err)
+	}
+	if n != len(chunk) {
+		return fmt.Errorf("write(): expected to write %d bytes but wrote %d", len(chunk), n)
 	}
+	return nil
 }
 
 func readChunk(f serial.Port, chunk []byte) (err error) {
@@ -340,21 +415,3 @@ func readChunk(f serial.Port, chunk []byte) (err error) {
 	}
 	return nil
 }
-
-func cleanData(a []float64) (cleaned []float64, outliers []float64) {
-	cleaned = make([]float64, 0, len(a))
-	for i := 1; i < len(a); i += 2 {
-		if math.Abs(a[i]-a[i-1]) >= 10_000_000 {
-			if a[i] > a[i-1] {
-				outliers = append(outliers, a[i])
-				cleaned = append(cleaned, a[i-1])
-			} else {
-				outliers = append(outliers, a[i-1])
-				cleaned = append(cleaned, a[i])
-			}
-		} else {
-			cleaned = append(cleaned, a[i-1], a[i])
-		}
-	}
-	return
-}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sertest/usb2snes"
+	"time"
+)
+
+// benchSpec is a benchmark suite, usually loaded from a YAML file; see bench.yml.
+// Fields left out of a test fall back to defaults and then to the built-in values.
+type benchSpec struct {
+	Defaults testDefaults `yaml:"defaults"`
+	Tests    []testSpec   `yaml:"tests"`
+}
+
+// Warmup and Delay are pointers like Addr so that an explicit 0 in a test overrides a
+// nonzero default.
+type testDefaults struct {
+	Addr       *uint32        `yaml:"addr"`
+	Iterations int            `yaml:"iterations"`
+	Warmup     *int           `yaml:"warmup,omitempty"`
+	Delay      *time.Duration `yaml:"delay,omitempty"`
+}
+
+type testSpec struct {
+	Name  string `yaml:"name"`
+	Op    string `yaml:"op"`
+	Space string `yaml:"space"`
+	// Addr is the first address read or written.
+	Addr  *uint32  `yaml:"addr"`
+	Sizes []uint32 `yaml:"sizes"`
+	// Ranges is how many consecutive ranges of each size one VGET reads.
+	Ranges     int            `yaml:"ranges,omitempty"`
+	Iterations int            `yaml:"iterations"`
+	Warmup     *int           `yaml:"warmup,omitempty"`
+	Delay      *time.Duration `yaml:"delay,omitempty"`
+}
+
+const (
+	defaultAddr       = uint32(0xF50000)
+	defaultIterations = 500
+	defaultRanges     = 8
+)
+
+var specSpaces = map[string]usb2snes.Space{
+	"snes":   usb2snes.SpaceSNES,
+	"msu":    usb2snes.SpaceMSU,
+	"cmd":    usb2snes.SpaceCMD,
+	"config": usb2snes.SpaceCONFIG,
+}
+
+var specOps = map[string]usb2snes.Opcode{
+	"get":  usb2snes.OpGET,
+	"put":  usb2snes.OpPUT,
+	"vget": usb2snes.OpVGET,
+}
+
+// builtinSpec is the suite run by -get and -vget without -spec.
+func builtinSpec(get, vget bool, d testDefaults) *benchSpec {
+	s := &benchSpec{Defaults: d}
+	if vget {
+		s.Tests = append(s.Tests, testSpec{
+			Name:  "vget",
+			Op:    "vget",
+			Sizes: []uint32{0x01, 0x02, 0x04, 0x08, 0x10, 0x20, 0x40, 0x80, 0xFF},
+		})
+	}
+	if get {
+		s.Tests = append(s.Tests, testSpec{
+			Name: "get",
+			Op:   "get",
+			Sizes: []uint32{
+				0x01 * 8, 0x02 * 8, 0x04 * 8, 0x08 * 8, 0x10 * 8, 0x20 * 8, 0x40 * 8, 0x80 * 8, 0xFF * 8,
+				0x1000, 0x2000},
+		})
+	}
+	return s
+}
+
+func loadSpec(name string) (*benchSpec, error) {
+	in, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &benchSpec{}
+	if err = yaml.UnmarshalStrict(in, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err = s.resolve(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return s, nil
+}
+
+// resolve fills in defaults and checks every test.
+func (s *benchSpec) resolve() error {
+	if len(s.Tests) == 0 {
+		return fmt.Errorf("no tests")
+	}
+	d := &s.Defaults
+	if d.Iterations < 0 || (d.Warmup != nil && *d.Warmup < 0) || (d.Delay != nil && *d.Delay < 0) {
+		return fmt.Errorf("defaults: negative iterations, warmup or delay")
+	}
+	for i := range s.Tests {
+		t := &s.Tests[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("test%d", i+1)
+		}
+		if err := t.resolve(&s.Defaults); err != nil {
+			return fmt.Errorf("test %q: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func (t *testSpec) resolve(d *testDefaults) error {
+	if t.Op == "" {
+		t.Op = "get"
+	}
+	op, ok := specOps[t.Op]
+	if !ok {
+		return fmt.Errorf("unknown op %q (want get, put or vget)", t.Op)
+	}
+	if t.Space == "" {
+		t.Space = "snes"
+	}
+	if _, ok = specSpaces[t.Space]; !ok {
+		return fmt.Errorf("unknown space %q (want snes, msu, cmd or config)", t.Space)
+	}
+
+	if t.Addr == nil {
+		t.Addr = d.Addr
+	}
+	if t.Addr == nil {
+		addr := defaultAddr
+		t.Addr = &addr
+	}
+	if t.Iterations == 0 {
+		t.Iterations = d.Iterations
+	}
+	if t.Iterations == 0 {
+		t.Iterations = defaultIterations
+	}
+	if t.Warmup == nil {
+		t.Warmup = d.Warmup
+	}
+	if t.Warmup == nil {
+		t.Warmup = new(int)
+	}
+	if t.Delay == nil {
+		t.Delay = d.Delay
+	}
+	if t.Delay == nil {
+		t.Delay = new(time.Duration)
+	}
+	if t.Iterations < 0 || *t.Warmup < 0 || *t.Delay < 0 {
+		return fmt.Errorf("negative iterations, warmup or delay")
+	}
+	if len(t.Sizes) == 0 {
+		return fmt.Errorf("no sizes")
+	}
+
+	if op != usb2snes.OpVGET {
+		if t.Ranges != 0 {
+			return fmt.Errorf("ranges only applies to vget")
+		}
+		for _, size := range t.Sizes {
+			if size == 0 {
+				return fmt.Errorf("size must not be 0")
+			}
+		}
+		return nil
+	}
+
+	// VGET only reads SNES memory and packs up to 8 ranges of 24-bit addresses into
+	// one 64-byte command:
+	if t.Space != "snes" {
+		return fmt.Errorf("vget only reads the snes space")
+	}
+	if t.Ranges == 0 {
+		t.Ranges = defaultRanges
+	}
+	if t.Ranges < 1 || t.Ranges > usb2snes.MaxVGETRanges {
+		return fmt.Errorf("ranges must be 1 to %d", usb2snes.MaxVGETRanges)
+	}
+	for _, size := range t.Sizes {
+		switch {
+		case size == 0:
+			return fmt.Errorf("size must not be 0")
+		case size > usb2snes.MaxVGETSize:
+			return fmt.Errorf("vget size %d exceeds %d", size, usb2snes.MaxVGETSize)
+		case size > 255 && t.Ranges != 1:
+			// FlagSIZE_BIT9 only extends the first range:
+			return fmt.Errorf("vget size %d needs ranges: 1", size)
+		}
+		if *t.Addr+size*uint32(t.Ranges) > 0x1000000 {
+			return fmt.Errorf("vget of %d x %d bytes runs past $FFFFFF", t.Ranges, size)
+		}
+	}
+	return nil
+}
+
+func (t *testSpec) String() string {
+	s := fmt.Sprintf("%s: %s %s $%06X, %d iterations", t.Name, t.Op, t.Space, *t.Addr, t.Iterations)
+	if t.Op == "vget" {
+		s += fmt.Sprintf(", %d ranges", t.Ranges)
+	}
+	if *t.Warmup > 0 {
+		s += fmt.Sprintf(", %d warmup", *t.Warmup)
+	}
+	if *t.Delay > 0 {
+		s += fmt.Sprintf(", %v delay", *t.Delay)
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sertest/usb2snes"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	name    string
+	args    string
+	about   string
+	offline bool
+	run     func(c *usb2snes.Conn, args []string) error
+}
+
+var commands = []command{
+	{"backup", "[-dir dir] [-every interval]", "save the running ROM's SRAM to a timestamped snapshot", false, cmdBACKUP},
+	{"restore", "[-dir dir] [-force] [snapshot.srm]", "write a snapshot, by default the latest, back to SRAM", false, cmdRESTORE},
+	{"list", "[-dir dir] [rom name]", "list snapshots with their hashes", true, cmdLIST},
+	{"write", "[-no-verify] [-force] <file.srm>", "upload a save file straight into cart SRAM", false, cmdWRITE},
+}
+
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: fxpaksram [-port name] <command> [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(o, "  %-7s %s\n          %s\n", cmd.name, cmd.args, cmd.about)
+	}
+	fmt.Fprintf(o, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() < 1 {
+		usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+			break
+		}
+	}
+	if cmd == nil {
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	var c *usb2snes.Conn
+	if !cmd.offline {
+		var err error
+		if c, err = usb2snes.OpenDevice(*portName); err != nil {
+			log.Println(err)
+			return exitNoDevice
+		}
+		defer c.Close()
+	}
+
+	err := cmd.run(c, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: fxpaksram %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
+
+func cmdWRITE(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("write", flag.ContinueOnError)
+	noVerify := fs.Bool("no-verify", false, "skip reading SRAM back after the upload")
+	force := fs.Bool("force", false, "upload even if the file size does not match the ROM's SRAM size")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	h, err := c.ROMHeader()
+	if err != nil {
+		return err
+	}
+	log.Printf("%s: running '%s' with %d bytes of SRAM\n", c.Name, h.Title, h.SRAMSize)
+
+	log.Printf("%s: write %d bytes to SRAM\n", c.Name, len(data))
+	err = c.RestoreSRAM(data, usb2snes.RestoreSRAMOptions{AnySize: *force, NoVerify: *noVerify})
+	if err != nil {
+		return err
+	}
+	if *noVerify {
+		return nil
+	}
+	log.Printf("%s: verified\n", c.Name)
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sertest/usb2snes"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultSnapshotDir = "sram-snapshots"
+
+// romName returns the directory name snapshots of the running ROM are kept under.
+func romName(c *usb2snes.Conn) (string, error) {
+	info, err := c.Info()
+	if err != nil {
+		return "", err
+	}
+	if info.ROM == "" || usb2snes.IsMenu(info.ROM) {
+		return "", fmt.Errorf("no ROM running")
+	}
+	return sanitize(strings.TrimSuffix(path.Base(info.ROM), path.Ext(info.ROM))), nil
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+type snapshot struct {
+	path string
+	time time.Time
+	size int
+	hash string
+}
+
+// snapshots returns the snapshots in dir, oldest first.
+func snapshots(dir string) ([]snapshot, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []snapshot
+	for _, fi := range fis {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".srm" {
+			continue
+		}
+		p := filepath.Join(dir, fi.Name())
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snapshot{path: p, time: fi.ModTime(), size: len(data), hash: hashHex(data)})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].path < snaps[j].path })
+	return snaps, nil
+}
+
+func hashHex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func readSRAM(c *usb2snes.Conn) ([]byte, error) {
+	size, err := c.SRAMSize()
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("running ROM has no SRAM")
+	}
+	return c.Get(usb2snes.SpaceSNES, usb2snes.SRAMBase, uint32(size))
+}
+
+func cmdBACKUP(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := fs.String("dir", defaultSnapshotDir, "directory to keep snapshots in")
+	every := fs.Duration("every", 0, "keep taking snapshots at this interval until interrupted")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	if *every == 0 {
+		_, err := backup(c, *dir, nil)
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(*every)
+	defer ticker.Stop()
+
+	for {
+		// a failed snapshot is logged but does not end periodic backups; the game may
+		// just be between ROMs:
+		if _, err := backup(c, *dir, nil); err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigs:
+			return nil
+		}
+	}
+}
+
+// backup saves the running ROM's SRAM unless it is identical to the latest snapshot
+// and returns the snapshot's path. data is the SRAM if the caller already read it, or
+// nil to read it here.
+func backup(c *usb2snes.Conn, dir string, data []byte) (string, error) {
+	name, err := romName(c)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		if data, err = readSRAM(c); err != nil {
+			return "", err
+		}
+	}
+
+	romDir := filepath.Join(dir, name)
+	snaps, err := snapshots(romDir)
+	if err != nil {
+		return "", err
+	}
+	if len(snaps) > 0 && snaps[len(snaps)-1].hash == hashHex(data) {
+		latest := snaps[len(snaps)-1].path
+		log.Printf("%s: SRAM unchanged since '%s'\n", c.Name, latest)
+		return latest, nil
+	}
+
+	if err = os.MkdirAll(romDir, 0755); err != nil {
+		return "", err
+	}
+	timestamp := strings.ReplaceAll(time.Now().UTC().Format("2006-01-02T15-04-05.000000"), ".", "-")
+	p := filepath.Join(romDir, timestamp+".srm")
+	if err = ioutil.WriteFile(p, data, 0644); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s: %d bytes of SRAM saved to '%s'\n", c.Name, len(data), p)
+	return p, nil
+}
+
+func cmdRESTORE(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := fs.String("dir", defaultSnapshotDir, "directory snapshots are kept in")
+	force := fs.Bool("force", false, "restore a snapshot taken of a different ROM")
+	if fs.Parse(args) != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+
+	name, err := romName(c)
+	if err != nil {
+		return err
+	}
+
+	p := ""
+	if fs.NArg() == 1 {
+		p = fs.Arg(0)
+		// snapshots are kept in a directory named after their ROM:
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		if from := filepath.Base(filepath.Dir(abs)); from != name && !*force {
+			return fmt.Errorf("'%s' is not a snapshot of '%s' (use -force to restore it anyway)", p, name)
+		}
+	} else {
+		snaps, err := snapshots(filepath.Join(*dir, name))
+		if err != nil {
+			return err
+		}
+		if len(snaps) == 0 {
+			return fmt.Errorf("no snapshots for '%s' in '%s'", name, *dir)
+		}
+		p = snaps[len(snaps)-1].path
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	// keep what is there now in case the wrong snapshot gets restored:
+	cur, err := readSRAM(c)
+	if err != nil {
+		return fmt.Errorf("backup before restore: %w", err)
+	}
+	if !bytes.Equal(cur, data) {
+		if _, err = backup(c, *dir, cur); err != nil {
+			return fmt.Errorf("backup before restore: %w", err)
+		}
+	}
+
+	log.Printf("%s: restore '%s'\n", c.Name, p)
+	if err = c.RestoreSRAM(data, usb2snes.RestoreSRAMOptions{}); err != nil {
+		return err
+	}
+	log.Printf("%s: verified\n", c.Name)
+	return nil
+}
+
+func cmdLIST(c *usb2snes.Conn, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	dir := fs.String("dir", defaultSnapshotDir, "directory snapshots are kept in")
+	if fs.Parse(args) != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+
+	var names []string
+	if fs.NArg() == 1 {
+		names = []string{sanitize(fs.Arg(0))}
+	} else {
+		fis, err := ioutil.ReadDir(*dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() {
+				names = append(names, fi.Name())
+			}
+		}
+	}
+
+	for _, name := range names {
+		snaps, err := snapshots(filepath.Join(*dir, name))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s:\n", name)
+		for i, s := range snaps {
+			fmt.Printf("  %3d  %s  %7d  %s  %s\n", i+1, s.time.Format("2006-01-02 15:04:05"), s.size, s.hash[:16], filepath.Base(s.path))
+		}
+	}
+	return nil
+}
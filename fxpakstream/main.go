@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sertest/usb2snes"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+// addrRange is an inclusive range of 24-bit addresses.
+type addrRange struct {
+	lo, hi uint32
+}
+
+type rangeList []addrRange
+
+func (l *rangeList) String() string {
+	s := make([]string, len(*l))
+	for i, r := range *l {
+		s[i] = fmt.Sprintf("%06x-%06x", r.lo, r.hi)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses addr or lo-hi in hex.
+func (l *rangeList) Set(s string) error {
+	lo, hi := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	a, err := parseAddr(lo)
+	if err != nil {
+		return err
+	}
+	b, err := parseAddr(hi)
+	if err != nil {
+		return err
+	}
+	if b < a {
+		return fmt.Errorf("empty range %q", s)
+	}
+	*l = append(*l, addrRange{a, b})
+	return nil
+}
+
+func (l rangeList) match(addr uint32) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, r := range l {
+		if addr >= r.lo && addr <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	n, err := strconv.ParseUint(s, 16, 24)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint32(n), nil
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	var ranges rangeList
+
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	source := flag.String("source", "wram", "what to stream: wram (CPU writes to WRAM) or bus (all snooped bus writes)")
+	burst := flag.Bool("burst", false, "let the firmware batch packets into 512-byte blocks")
+	count := flag.Int("n", 0, "stop after printing this many records, not counting those -addr filters out (0: until interrupted)")
+	buffer := flag.Int("buffer", 4096, "records to buffer before pushing back on the firmware")
+	flag.Var(&ranges, "addr", "only print writes to this hex address or lo-hi range (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fxpakstream [flags]\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		return exitUsage
+	}
+
+	var src usb2snes.StreamSource
+	switch *source {
+	case "wram":
+		src = usb2snes.StreamWRAM
+	case "bus":
+		src = usb2snes.StreamBus
+	default:
+		log.Printf("unknown source %q\n", *source)
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	s, err := c.OpenStream(src, *burst, *buffer)
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	log.Printf("%s: streaming %s writes\n", c.Name, *source)
+
+	start := time.Now()
+	n, printed := 0, 0
+loop:
+	for {
+		select {
+		case <-sigs:
+			break loop
+		case r, ok := <-s.C:
+			if !ok {
+				log.Printf("%s: stream ended by the device\n", c.Name)
+				break loop
+			}
+			n++
+			if !ranges.match(r.Addr) {
+				continue
+			}
+			fmt.Printf("%12.6f $%06x = $%02x\n", time.Since(start).Seconds(), r.Addr, r.Value)
+			if printed++; *count > 0 && printed >= *count {
+				break loop
+			}
+		}
+	}
+
+	if err = s.Stop(); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	elapsed := time.Since(start)
+	log.Printf("%s: %d records (%d printed) in %v, %.0f records/s\n", c.Name, n, printed, elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+	return exitOK
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"sertest/usb2snes"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	utc := flag.Bool("utc", false, "treat the cart's clock as UTC instead of local time")
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "usage: fxpaktime [flags] [get | set [timestamp]]\n\n")
+		fmt.Fprintf(o, "  get              show the cart's clock and its drift from the host (default)\n")
+		fmt.Fprintf(o, "  set              set the cart's clock from the host clock\n")
+		fmt.Fprintf(o, "  set <timestamp>  set the cart's clock to an RFC 3339 or \"2006-01-02 15:04:05\" time\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	loc := time.Local
+	if *utc {
+		loc = time.UTC
+	}
+
+	cmd := "get"
+	if flag.NArg() > 0 {
+		cmd = flag.Arg(0)
+	}
+
+	var at time.Time
+	switch {
+	case cmd == "get" && flag.NArg() <= 1:
+	case cmd == "set" && flag.NArg() == 1:
+	case cmd == "set" && flag.NArg() == 2:
+		var err error
+		if at, err = parseTimestamp(flag.Arg(1), loc); err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+	default:
+		flag.Usage()
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	if info, err := c.Info(); err == nil {
+		srtc := "no"
+		if info.Features&usb2snes.FeatSRTC != 0 {
+			srtc = "yes"
+		}
+		log.Printf("%s: running '%s'; uses S-RTC: %s\n", c.Name, info.ROM, srtc)
+	}
+
+	if cmd == "set" {
+		if at.IsZero() {
+			// the RTC counts whole seconds so wait for the host clock to tick over:
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+			at = time.Now().In(loc)
+		}
+		if err = c.SetTime(at.In(loc)); err != nil {
+			log.Println(err)
+			return exitError
+		}
+		log.Printf("%s: clock set to %s\n", c.Name, at.In(loc).Format("2006-01-02 15:04:05 Mon"))
+	}
+
+	before := time.Now()
+	t, err := c.Time(loc)
+	after := time.Now()
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+
+	// compare against the middle of the round trip:
+	host := before.Add(after.Sub(before) / 2)
+	drift := t.Sub(host).Round(time.Second)
+
+	log.Printf("%s: cart clock %s\n", c.Name, t.Format("2006-01-02 15:04:05 Mon"))
+	log.Printf("%s: host clock %s\n", c.Name, host.In(loc).Format("2006-01-02 15:04:05 Mon"))
+	log.Printf("%s: drift      %+v\n", c.Name, drift)
+	return exitOK
+}
+
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sertest/snestime"
+	"sertest/usb2snes"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	portName := flag.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	format := flag.String("format", "text", "output: text (a line per change), jsonl (a JSON object per change) or tui (a live table)")
+	regionName := flag.String("region", "ntsc", "console region: ntsc or pal")
+	interlace := flag.Bool("interlace", false, "the game runs in interlace mode")
+	strategyName := flag.String("strategy", "hybrid", "how to wait for each frame: ticker, sleep, spin or hybrid")
+	frames := flag.Uint64("frames", 0, "stop after this many frames (0: until interrupted)")
+	lock := flag.String("lock", "none", "phase-lock polling to the console's frames: none or counter (VGET a frame counter)")
+	counter := flag.String("counter", "F5001A", "hex address of the game's frame counter for -lock counter")
+	counterSize := flag.Int("counter-size", 1, "size of the frame counter in bytes")
+	target := flag.Duration("target", 2*time.Millisecond, "with -lock, how long after the start of the console's frame to poll")
+	lockEvery := flag.Uint64("lock-every", 30, "with -lock, measure the frame edge about every this many frames")
+	overscan := flag.Bool("overscan", false, "with -lock, the game runs with overscan, so vblank starts at line 240 rather than 225")
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "usage: fxpakwatch [flags] [name=]addr[:type]...\n\n")
+		fmt.Fprintf(o, "addr is in hex, e.g. F50010 for WRAM $7E0010. type is u8 (the default), u16 or u24\n")
+		fmt.Fprintf(o, "(little-endian), or bN or bN-M for bits N..M of the little-endian value at addr.\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
+
+	if flag.NArg() == 0 || *lockEvery < 1 {
+		flag.Usage()
+		return exitUsage
+	}
+
+	watches := make([]*watch, flag.NArg())
+	for i, arg := range flag.Args() {
+		w, err := parseWatch(arg)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		watches[i] = w
+	}
+	batches := plan(watches)
+
+	region, err := snestime.ParseRegion(*regionName)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	strategy, err := snestime.ParseStrategy(*strategyName)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	timing := snestime.Timing{Region: region, Interlace: *interlace}
+
+	// The counter is bumped early in the game's NMI handler, i.e. at the start of
+	// vblank; edge turns that into the start of the frame. It reads through vget so
+	// that it can share the port with the polling, one VGET at a time. NMIEdge can't:
+	// it holds the port until the next NMI, which would hold up the polling by as much
+	// as a frame.
+	vblank := timing.VBlankStart(*overscan)
+	var edge func(vget func(addr uint32, size int) ([]byte, error)) (time.Time, error)
+	switch *lock {
+	case "none":
+	case "counter":
+		addr, err := parseAddr(*counter)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		if *counterSize < 1 || *counterSize > usb2snes.MaxVGETSize {
+			log.Printf("bad counter size %d\n", *counterSize)
+			return exitUsage
+		}
+		timeout := 3 * timing.AverageFrameDuration()
+		edge = func(vget func(addr uint32, size int) ([]byte, error)) (time.Time, error) {
+			at, err := usb2snes.FindEdge(func() ([]byte, error) {
+				return vget(addr, *counterSize)
+			}, timeout)
+			return at.Add(-vblank), err
+		}
+	default:
+		log.Printf("unknown lock source %q\n", *lock)
+		return exitUsage
+	}
+	if edge != nil && strategy == snestime.StrategyTicker {
+		log.Println("the ticker strategy cannot be steered; pick another -strategy for -lock")
+		return exitUsage
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+
+	start := time.Now()
+	var out output
+	switch *format {
+	case "text":
+		out = &textOutput{w: stdout, start: start}
+	case "jsonl":
+		out = &jsonOutput{enc: json.NewEncoder(stdout)}
+	case "tui":
+		out = newTUIOutput(stdout, start, watches)
+	default:
+		log.Printf("unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	pacer := snestime.NewFramePacer(timing, strategy)
+	pll := snestime.NewPhaseLock(pacer, *target)
+
+	// the edge sampler runs beside the pacer, so the two take turns with c
+	var mu sync.Mutex
+	var edges <-chan snestime.Edge
+	if edge != nil {
+		edges = snestime.SampleEdges(ctx, time.Duration(*lockEvery)*timing.AverageFrameDuration(), func() (time.Time, error) {
+			return edge(func(addr uint32, size int) ([]byte, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return c.VGet(addr, size)
+			})
+		})
+	}
+
+	log.Printf("%s: watching %d values with %d VGETs per frame\n", c.Name, len(watches), len(batches))
+	values := make([]uint32, len(watches))
+	data := make([][][]byte, len(batches))
+	var polled, missed, changed uint64
+	err = pacer.Run(ctx, func(f snestime.Frame) error {
+		if *frames > 0 && f.N >= *frames {
+			return errDone
+		}
+		missed += f.Missed
+
+		for i, b := range batches {
+			var err error
+			mu.Lock()
+			data[i], err = c.VGetRanges(b)
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+
+		var changes []change
+		for i, w := range watches {
+			v := w.decode(data[w.batch][w.span])
+			if polled == 0 || v != values[i] {
+				changes = append(changes, change{w: w, old: values[i], new: v, initial: polled == 0})
+			}
+			values[i] = v
+		}
+		if polled > 0 {
+			changed += uint64(len(changes))
+		}
+		polled++
+		if err := out.frame(f.N, f.At, changes); err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			if err := stdout.Flush(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case e := <-edges:
+			if errors.Is(e.Err, usb2snes.ErrNoEdge) {
+				log.Printf("frame %d: %v\n", f.N, e.Err)
+				return nil
+			}
+			if e.Err != nil {
+				return e.Err
+			}
+			pll.Update(f, e.At)
+		default:
+		}
+		return nil
+	})
+	// let the sampler finish with c before it is closed
+	cancel()
+	if edges != nil {
+		for range edges {
+		}
+	}
+	if err != nil && err != errDone && err != context.Canceled {
+		log.Println(err)
+		return exitError
+	}
+
+	if err := stdout.Flush(); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	log.Printf("%s: %d changes over %d frames polled, %d frames missed\n", c.Name, changed, polled, missed)
+	return exitOK
+}
+
+var errDone = errors.New("done")
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// change is a watch whose value differs from the previous frame's; on the first frame
+// every watch is reported with initial set.
+type change struct {
+	w        *watch
+	old, new uint32
+	initial  bool
+}
+
+// output presents the changes seen on one frame.
+type output interface {
+	frame(n uint64, at time.Time, changes []change) error
+}
+
+// textOutput prints one line per change.
+type textOutput struct {
+	w     io.Writer
+	start time.Time
+}
+
+func (o *textOutput) frame(n uint64, at time.Time, changes []change) error {
+	for _, c := range changes {
+		was := ""
+		if !c.initial {
+			was = " (was " + c.w.format(c.old) + ")"
+		}
+		_, err := fmt.Fprintf(o.w, "%8d %12.6f %s = %s%s\n", n, at.Sub(o.start).Seconds(), c.w.name, c.w.format(c.new), was)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonOutput writes one JSON object per change.
+type jsonOutput struct {
+	enc *json.Encoder
+}
+
+type jsonChange struct {
+	Frame   uint64    `json:"frame"`
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name"`
+	Addr    uint32    `json:"addr"`
+	Type    string    `json:"type"`
+	Value   uint32    `json:"value"`
+	Old     *uint32   `json:"old,omitempty"`
+	Initial bool      `json:"initial,omitempty"`
+}
+
+func (o *jsonOutput) frame(n uint64, at time.Time, changes []change) error {
+	for _, c := range changes {
+		r := jsonChange{
+			Frame:   n,
+			Time:    at.UTC(),
+			Name:    c.w.name,
+			Addr:    c.w.addr,
+			Type:    c.w.typ,
+			Value:   c.new,
+			Initial: c.initial,
+		}
+		if !c.initial {
+			old := c.old
+			r.Old = &old
+		}
+		if err := o.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tuiOutput redraws a table of every watch whenever something changes.
+type tuiOutput struct {
+	w       io.Writer
+	start   time.Time
+	watches []*watch
+	rows    map[*watch]*tuiRow
+}
+
+type tuiRow struct {
+	value   uint32
+	changes int
+	frame   uint64
+	at      time.Time
+}
+
+func newTUIOutput(w io.Writer, start time.Time, watches []*watch) *tuiOutput {
+	o := &tuiOutput{w: w, start: start, watches: watches, rows: make(map[*watch]*tuiRow)}
+	for _, w := range watches {
+		o.rows[w] = &tuiRow{}
+	}
+	return o
+}
+
+func (o *tuiOutput) frame(n uint64, at time.Time, changes []change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, c := range changes {
+		r := o.rows[c.w]
+		r.value, r.frame, r.at = c.new, n, at
+		if !c.initial {
+			r.changes++
+		}
+	}
+
+	var sb strings.Builder
+	// home the cursor and clear the screen:
+	sb.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&sb, "frame %d  %.3fs\n\n", n, at.Sub(o.start).Seconds())
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "name\taddr\ttype\tvalue\tchanges\tlast frame\tlast change\t")
+	for _, w := range o.watches {
+		r := o.rows[w]
+		fmt.Fprintf(tw, "%s\t$%06x\t%s\t%s\t%d\t%d\t%.3fs\t\n", w.name, w.addr, w.typ, w.format(r.value), r.changes, r.frame, r.at.Sub(o.start).Seconds())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(o.w, sb.String())
+	return err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sertest/usb2snes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// watch is one value to poll. Its bytes are read little-endian from addr and, for a
+// bitfield, bits lo..hi of the result are kept.
+type watch struct {
+	name   string
+	addr   uint32
+	typ    string
+	size   int
+	lo, hi uint
+
+	// where the value sits in the poll plan:
+	batch, span, offset int
+}
+
+// parseWatch parses [name=]addr[:type] with addr in hex and type one of u8 (the
+// default), u16, u24, bN or bN-M for bits N..M of a little-endian value.
+func parseWatch(s string) (*watch, error) {
+	w := &watch{typ: "u8"}
+	spec := s
+	if i := strings.IndexByte(spec, '='); i >= 0 {
+		w.name, spec = spec[:i], spec[i+1:]
+	}
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		w.typ, spec = strings.ToLower(spec[i+1:]), spec[:i]
+	}
+
+	var err error
+	if w.addr, err = parseAddr(spec); err != nil {
+		return nil, err
+	}
+	if w.name == "" {
+		w.name = fmt.Sprintf("$%06x", w.addr)
+	}
+
+	switch w.typ {
+	case "u8":
+		w.size, w.hi = 1, 7
+	case "u16":
+		w.size, w.hi = 2, 15
+	case "u24":
+		w.size, w.hi = 3, 23
+	default:
+		if !strings.HasPrefix(w.typ, "b") {
+			return nil, fmt.Errorf("%s: unknown type %q (want u8, u16, u24, bN or bN-M)", s, w.typ)
+		}
+		lo, hi := w.typ[1:], w.typ[1:]
+		if i := strings.IndexByte(lo, '-'); i >= 0 {
+			lo, hi = lo[:i], lo[i+1:]
+		}
+		l, err1 := strconv.ParseUint(lo, 10, 8)
+		h, err2 := strconv.ParseUint(hi, 10, 8)
+		if err1 != nil || err2 != nil || h < l || h > 23 {
+			return nil, fmt.Errorf("%s: bad bitfield %q (want bits within 0..23)", s, w.typ)
+		}
+		w.size, w.lo, w.hi = int(h/8)+1, uint(l), uint(h)
+	}
+	if w.addr+uint32(w.size) > 0x1000000 {
+		return nil, fmt.Errorf("%s: runs past $ffffff", s)
+	}
+	return w, nil
+}
+
+// decode extracts the watch's value from the bytes at its offset.
+func (w *watch) decode(b []byte) uint32 {
+	var v uint32
+	for i := w.size - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[w.offset+i])
+	}
+	return v >> w.lo & (1<<(w.hi-w.lo+1) - 1)
+}
+
+// format prints v in hex sized to the type, or in decimal for a bitfield.
+func (w *watch) format(v uint32) string {
+	switch w.typ {
+	case "u8":
+		return fmt.Sprintf("$%02x", v)
+	case "u16":
+		return fmt.Sprintf("$%04x", v)
+	case "u24":
+		return fmt.Sprintf("$%06x", v)
+	default:
+		return strconv.FormatUint(uint64(v), 10)
+	}
+}
+
+// mergeGap is how many unwatched bytes may sit between two watches that are read as
+// one range; reading a few extra bytes is cheaper than spending another tuple.
+const mergeGap = 16
+
+// plan groups the watches into as few VGET commands as it can: nearby watches share a
+// range, and each command carries up to usb2snes.MaxVGETRanges ranges. Each watch is
+// told where its bytes land.
+func plan(watches []*watch) [][]usb2snes.VGETRange {
+	sorted := make([]*watch, len(watches))
+	copy(sorted, watches)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].addr < sorted[j].addr })
+
+	var batches [][]usb2snes.VGETRange
+	var spans []usb2snes.VGETRange
+	for _, w := range sorted {
+		end := w.addr + uint32(w.size)
+		if n := len(spans); n > 0 {
+			s := &spans[n-1]
+			// only the first range of a command may use FlagSIZE_BIT9:
+			max := uint32(255)
+			if n%usb2snes.MaxVGETRanges == 1 {
+				max = usb2snes.MaxVGETSize
+			}
+			if w.addr <= s.Addr+uint32(s.Size)+mergeGap && end-s.Addr <= max {
+				if size := int(end - s.Addr); size > s.Size {
+					s.Size = size
+				}
+				w.span, w.offset = n-1, int(w.addr-s.Addr)
+				continue
+			}
+		}
+		spans = append(spans, usb2snes.VGETRange{Addr: w.addr, Size: w.size})
+		w.span, w.offset = len(spans)-1, 0
+	}
+
+	for i := 0; i < len(spans); i += usb2snes.MaxVGETRanges {
+		j := i + usb2snes.MaxVGETRanges
+		if j > len(spans) {
+			j = len(spans)
+		}
+		batches = append(batches, spans[i:j])
+	}
+	for _, w := range sorted {
+		w.batch, w.span = w.span/usb2snes.MaxVGETRanges, w.span%usb2snes.MaxVGETRanges
+	}
+	return batches
+}
+
+func parseAddr(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	n, err := strconv.ParseUint(s, 16, 24)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint32(n), nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sertest/usb2snes"
+	"testing"
+)
+
+func TestParseWatch(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+		name    string
+		addr    uint32
+		size    int
+		lo, hi  uint
+	}{
+		{spec: "F50010", name: "$f50010", addr: 0xF50010, size: 1, hi: 7},
+		{spec: "hp=$7e0010:U16", name: "hp", addr: 0x7E0010, size: 2, hi: 15},
+		{spec: "0xF50010:u24", name: "$f50010", addr: 0xF50010, size: 3, hi: 23},
+		{spec: "flag=F50010:b3", name: "flag", addr: 0xF50010, size: 1, lo: 3, hi: 3},
+		{spec: "F50010:b4-7", name: "$f50010", addr: 0xF50010, size: 1, lo: 4, hi: 7},
+		{spec: "F50010:b6-9", name: "$f50010", addr: 0xF50010, size: 2, lo: 6, hi: 9},
+		{spec: "F50010:b0-23", name: "$f50010", addr: 0xF50010, size: 3, lo: 0, hi: 23},
+		{spec: "FFFFFF", name: "$ffffff", addr: 0xFFFFFF, size: 1, hi: 7},
+		{spec: "FFFFFE:u16", name: "$fffffe", addr: 0xFFFFFE, size: 2, hi: 15},
+		{spec: "FFFFFF:u16", wantErr: true},
+		{spec: "FFFFFE:b8-16", wantErr: true},
+		{spec: "1000000", wantErr: true},
+		{spec: "F50010:s8", wantErr: true},
+		{spec: "F50010:b24", wantErr: true},
+		{spec: "F50010:b5-3", wantErr: true},
+		{spec: "F50010:b", wantErr: true},
+		{spec: "nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		w, err := parseWatch(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseWatch(%q) succeeded, want error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWatch(%q): %v", tt.spec, err)
+			continue
+		}
+		if w.name != tt.name || w.addr != tt.addr || w.size != tt.size || w.lo != tt.lo || w.hi != tt.hi {
+			t.Errorf("parseWatch(%q) = %q $%06x size %d bits %d-%d, want %q $%06x size %d bits %d-%d",
+				tt.spec, w.name, w.addr, w.size, w.lo, w.hi, tt.name, tt.addr, tt.size, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := []byte{0xAA, 0x34, 0x12, 0xF0, 0x5C}
+	tests := []struct {
+		typ    string
+		offset int
+		want   uint32
+	}{
+		{"u8", 0, 0xAA},
+		{"u16", 1, 0x1234},
+		{"u24", 1, 0xF01234},
+		{"u24", 2, 0x5CF012},
+		{"b0", 0, 0},
+		{"b1", 0, 1},
+		{"b4-7", 0, 0xA},
+		{"b4-7", 3, 0xF},
+		// spans the byte boundary: $5CF0 >> 6 & 0xF
+		{"b6-9", 3, 0x3},
+		{"b0-23", 1, 0xF01234},
+		{"b12-23", 1, 0xF01},
+	}
+	for _, tt := range tests {
+		w, err := parseWatch("F50000:" + tt.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.offset = tt.offset
+		if got := w.decode(data); got != tt.want {
+			t.Errorf("%s at %d = %#x, want %#x", tt.typ, tt.offset, got, tt.want)
+		}
+	}
+}
+
+// chain returns u8 watches every 16 bytes from from to to, close enough to share a
+// range.
+func chain(from, to uint32) []string {
+	var specs []string
+	for addr := from; addr <= to; addr += 0x10 {
+		specs = append(specs, fmt.Sprintf("%06X", addr))
+	}
+	return specs
+}
+
+func TestPlan(t *testing.T) {
+	// eight ranges 4KiB apart fill the first command:
+	var eight []string
+	var eightRanges []usb2snes.VGETRange
+	for i := uint32(0); i < 8; i++ {
+		addr := 0xF50000 + i*0x1000
+		eight = append(eight, fmt.Sprintf("%06X", addr))
+		eightRanges = append(eightRanges, usb2snes.VGETRange{Addr: addr, Size: 1})
+	}
+
+	type pos struct{ batch, span, offset int }
+	tests := []struct {
+		name    string
+		watches []string
+		want    [][]usb2snes.VGETRange
+		// where each watch lands, in the order given; checked when set
+		pos []pos
+	}{
+		{
+			name:    "within the gap",
+			watches: []string{"F50020:u16", "F50010"},
+			// 15 unwatched bytes between them:
+			want: [][]usb2snes.VGETRange{{{Addr: 0xF50010, Size: 0x12}}},
+			pos:  []pos{{0, 0, 0x10}, {0, 0, 0}},
+		},
+		{
+			name:    "past the gap",
+			watches: []string{"F50010", "F50022"},
+			want:    [][]usb2snes.VGETRange{{{Addr: 0xF50010, Size: 1}, {Addr: 0xF50022, Size: 1}}},
+			pos:     []pos{{0, 0, 0}, {0, 1, 0}},
+		},
+		{
+			name:    "overlapping",
+			watches: []string{"F50010:u24", "F50011:b0-3", "F50011"},
+			want:    [][]usb2snes.VGETRange{{{Addr: 0xF50010, Size: 3}}},
+			pos:     []pos{{0, 0, 0}, {0, 0, 1}, {0, 0, 1}},
+		},
+		{
+			name:    "large first range",
+			watches: chain(0xF50000, 0xF501F0),
+			want:    [][]usb2snes.VGETRange{{{Addr: 0xF50000, Size: 0x1F1}}},
+		},
+		{
+			name:    "first range at most 511",
+			watches: chain(0xF50000, 0xF50200),
+			want:    [][]usb2snes.VGETRange{{{Addr: 0xF50000, Size: 0x1F1}, {Addr: 0xF50200, Size: 1}}},
+		},
+		{
+			name:    "later ranges at most 255",
+			watches: append([]string{"F40000"}, chain(0xF50000, 0xF50100)...),
+			want:    [][]usb2snes.VGETRange{{{Addr: 0xF40000, Size: 1}, {Addr: 0xF50000, Size: 0xF1}, {Addr: 0xF50100, Size: 1}}},
+		},
+		{
+			name:    "second command",
+			watches: append(append([]string(nil), eight...), chain(0xF58000, 0xF581F0)...),
+			want: [][]usb2snes.VGETRange{
+				eightRanges,
+				// first of its command, so it may grow past 255:
+				{{Addr: 0xF58000, Size: 0x1F1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		watches := make([]*watch, len(tt.watches))
+		for i, s := range tt.watches {
+			w, err := parseWatch(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			watches[i] = w
+		}
+
+		got := plan(watches)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: plan = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i, w := range watches {
+			if tt.pos != nil {
+				if p := (pos{w.batch, w.span, w.offset}); p != tt.pos[i] {
+					t.Errorf("%s: %s at %+v, want %+v", tt.name, tt.watches[i], p, tt.pos[i])
+				}
+			}
+			r := got[w.batch][w.span]
+			if r.Addr+uint32(w.offset) != w.addr || w.offset+w.size > r.Size {
+				t.Errorf("%s: %s placed at %d in %d bytes at $%06X", tt.name, tt.watches[i], w.offset, r.Size, r.Addr)
+			}
+		}
+		for _, b := range got {
+			if _, err := usb2snes.MakeVGETRanges(b); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		}
+	}
+}
@@ -3,8 +3,9 @@ module sertest
 go 1.15
 
 require (
+	bazil.org/fuse v0.0.0-20200117225306-7b5117fecadc
 	github.com/aybabtme/uniplot v0.0.0-20151203143629-039c559e5e7e
 	go.bug.st/serial v1.6.1
 	golang.org/x/text v0.3.7
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
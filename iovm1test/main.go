@@ -11,14 +11,53 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/debug"
+	"sertest/bench"
+	"sertest/snestime"
+	"sertest/stats"
+	"sertest/usb2snes"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// Exit codes:
+const (
+	exitError = 1
+	exitUsage = 2
+)
+
+// interrupted is set once SIGINT or SIGTERM arrives so the test loops stop early and
+// main can put the cart back the way it found it.
+var interrupted int32
+
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) != 0
+}
+
+// outlierFilter separates the outliers from each test's summary.
+var outlierFilter stats.OutlierFilter
+
+// region is the video standard whose frames latencies are also reported in.
+var region snestime.Region
+
 func main() {
+	outliers := flag.String("outliers", "iqr", "outlier filter: none, iqr[:k], mad[:k] or fixed:duration")
+	regionName := flag.String("region", "ntsc", "report latencies in frames of this video standard: ntsc or pal")
 	flag.Parse()
 
+	var err error
+	if outlierFilter, err = stats.ParseOutlierFilter(*outliers); err != nil {
+		log.Println(err)
+		os.Exit(exitUsage)
+	}
+	if region, err = snestime.ParseRegion(*regionName); err != nil {
+		log.Println(err)
+		os.Exit(exitUsage)
+	}
+
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
 	timestamp := strings.ReplaceAll(time.Now().UTC().Format("2006-01-02T15-04-05.000000"), ".", "-")
 	logfilename := timestamp + ".txt"
@@ -30,9 +69,21 @@ func main() {
 		log.Println(err)
 		return
 	}
+	run := bench.NewRun("iovm1test")
+	run.Outliers = outlierFilter
+	run.Region = region
 	defer (func() {
 		logfile.Close()
 		fmt.Printf("Output written to '%s'\n", logfilename)
+		if len(run.Tests) == 0 {
+			return
+		}
+		names, err := run.Save(timestamp)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Results written to '%s'\n", strings.Join(names, "', '"))
 	})()
 	log.SetOutput(io.MultiWriter(logfile, os.Stdout))
 
@@ -57,6 +108,9 @@ func main() {
 		if port.SerialNumber == "DEMO00000000" {
 			portName = port.Name
 			log.Printf("%s: FX Pak Pro found\n", portName)
+			run.Device.Port = port.Name
+			run.Device.Serial = port.SerialNumber
+			run.Device.VID, run.Device.PID = port.VID, port.PID
 			break
 		}
 	}
@@ -102,17 +156,54 @@ func main() {
 	// Disable GC
 	debug.SetGCPercent(-1)
 
-	//speedTest(f)
+	//speedTest(f, run)
 
-	disableSram(f)
+	// Disable SRAM writes for the duration of the tests and make sure they get
+	// re-enabled on return, panic or signal:
+	c := usb2snes.NewConn(portName, f)
+	if info, err := c.Info(); err != nil {
+		log.Printf("%s: info: %v\n", portName, err)
+	} else {
+		log.Printf("%s: firmware %s\n", portName, info.Version)
+		run.SetInfo(info)
+	}
+
+	log.Printf("disable SRAM writes\n")
+	sram, err := c.ProtectSRAM()
+	if err != nil {
+		log.Printf("%s: %v\n", portName, err)
+		return
+	}
+	defer (func() {
+		log.Printf("enable SRAM writes\n")
+		if err := sram.Restore(); err != nil {
+			log.Printf("%s: %v\n", portName, err)
+		}
+	})()
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go (func() {
+		<-sigs
+		log.Printf("interrupted; stopping tests\n")
+		atomic.StoreInt32(&interrupted, 1)
+		// a second signal means the tests are stuck in a read on the same port, which
+		// may swallow the response; send the restore anyway but only wait so long for
+		// its response before bailing:
+		<-sigs
+		log.Printf("enable SRAM writes\n")
+		_ = c.SetReadTimeout(time.Second)
+		if err := sram.Restore(); err != nil {
+			log.Printf("%s: %v\n", portName, err)
+		}
+		os.Exit(exitError)
+	})()
 
 	//iovmTest1(f)
 	//iovmTest2(f)
-	speedTest2(f)
-
-	//speedTest(f)
+	speedTest2(f, run)
 
-	//enableSram(f)
+	//speedTest(f, run)
 }
 
 func readUntilTimeout(f serial.Port, cb func([]byte)) {
@@ -165,53 +256,15 @@ func write(f serial.Port, b []byte) (err error) {
 	return
 }
 
-func disableSram(f serial.Port) {
-	var sb [512]byte
-	sb[0] = byte('U')
-	sb[1] = byte('S')
-	sb[2] = byte('B')
-	sb[3] = byte('A')
-	sb[4] = byte(OpSRAM_ENABLE)
-	sb[5] = byte(SpaceSNES)
-	sb[6] = byte(0)
-	// disable SRAM:
-	sb[7] = 0
-
-	log.Printf("disable SRAM writes\n")
-	if write(f, sb[:]) != nil {
-		return
-	}
-	readUntilTimeout(f, nil)
-}
-
-func enableSram(f serial.Port) {
-	var sb [512]byte
-	sb[0] = byte('U')
-	sb[1] = byte('S')
-	sb[2] = byte('B')
-	sb[3] = byte('A')
-	sb[4] = byte(OpSRAM_ENABLE)
-	sb[5] = byte(SpaceSNES)
-	sb[6] = byte(0)
-	// enable SRAM:
-	sb[7] = 1
-
-	log.Printf("enable SRAM writes\n")
-	if write(f, sb[:]) != nil {
-		return
-	}
-	readUntilTimeout(f, nil)
-}
-
 func iovmTest1(f serial.Port) {
 	var sb [64]byte
 	sb[0] = byte('U')
 	sb[1] = byte('S')
 	sb[2] = byte('B')
 	sb[3] = byte('A')
-	sb[4] = byte(OpIOVM_EXEC)
-	sb[5] = byte(SpaceSNES)
-	sb[6] = byte(FlagDATA64B)
+	sb[4] = byte(usb2snes.OpIOVM_EXEC)
+	sb[5] = byte(usb2snes.SpaceSNES)
+	sb[6] = byte(usb2snes.FlagDATA64B)
 
 	b := sb[8:8:cap(sb)]
 	// wait until [$2C00] & $FF == 0:
@@ -238,9 +291,9 @@ func iovmTest2(f serial.Port) {
 	sb[1] = byte('S')
 	sb[2] = byte('B')
 	sb[3] = byte('A')
-	sb[4] = byte(OpIOVM_EXEC)
-	sb[5] = byte(SpaceSNES)
-	sb[6] = byte(FlagDATA64B)
+	sb[4] = byte(usb2snes.OpIOVM_EXEC)
+	sb[5] = byte(usb2snes.SpaceSNES)
+	sb[6] = byte(usb2snes.FlagDATA64B)
 
 	b := sb[8:8:cap(sb)]
 	// wait until WRAM[$F343] < 25:
@@ -257,7 +310,7 @@ func iovmTest2(f serial.Port) {
 	})
 }
 
-func speedTest(f serial.Port) {
+func speedTest(f serial.Port, run *bench.Run) {
 	p := message.NewPrinter(language.AmericanEnglish)
 
 	log.Printf("1000 iterations of speed test\n")
@@ -269,28 +322,34 @@ func speedTest(f serial.Port) {
 	sb[1] = byte('S')
 	sb[2] = byte('B')
 	sb[3] = byte('A')
-	sb[4] = byte(OpIOVM_EXEC)
-	sb[5] = byte(SpaceSNES)
-	sb[6] = byte(FlagDATA64B)
+	sb[4] = byte(usb2snes.OpIOVM_EXEC)
+	sb[5] = byte(usb2snes.SpaceSNES)
+	sb[6] = byte(usb2snes.FlagDATA64B)
 	// 0-byte VM program just to test baseline latency:
 	sb[7] = 0
 
 	const iterations = 1000
-	times := [iterations]float64{}
+	rt := run.NewTest("speedTest", 0, iterations, map[string]interface{}{
+		"op":      "iovm_exec",
+		"program": hex.EncodeToString(sb[8 : 8+sb[7]]),
+	})
 
 	start := time.Now()
 	lastWrite := start
-	for i := 0; i < iterations; i++ {
+	i := 0
+	for ; i < iterations && !isInterrupted(); i++ {
 		// write:
 		lastWrite = time.Now()
 		n, err := f.Write(sb[:])
 		if err != nil {
 			log.Printf("write(): %v\n", err)
+			rt.Fail(i, err)
 			continue
 		}
 		// log.Printf("write(): wrote %d bytes\n", n)
 		if n != len(sb[:]) {
 			log.Printf("write(): expected to write 64 bytes but wrote %d\n", n)
+			rt.Fail(i, fmt.Errorf("short write of %d bytes", n))
 			continue
 		}
 
@@ -298,21 +357,25 @@ func speedTest(f serial.Port) {
 		err = readChunk(f, tmp[:])
 		if err != nil {
 			log.Printf("readChunk(): %v\n", err)
+			rt.Fail(i, err)
 			continue
 		}
 		//log.Printf("VGET response:\n%s\n", hex.Dump(data))
 		//log.Printf("[$10] = $%02x; [$1A] = $%02x\n", data[0x10], data[0x1A])
 
-		times[i] = float64(time.Now().Sub(lastWrite).Nanoseconds())
+		rt.Record(i, time.Now().Sub(lastWrite))
 	}
+	rt.Truncate(i)
 
 	//end := time.Now()
 	//log.Printf("%#v ns total; %#v ns avg\n", end.Sub(start).Nanoseconds(), end.Sub(start).Nanoseconds() / iterations)
 
-	reportHistograms(times[:], p)
+	if err := bench.Report(log.Writer(), rt, outlierFilter, region, p); err != nil {
+		log.Println(err)
+	}
 }
 
-func speedTest2(f serial.Port) {
+func speedTest2(f serial.Port, run *bench.Run) {
 	p := message.NewPrinter(language.AmericanEnglish)
 
 	log.Printf("1000 iterations of speed test\n")
@@ -324,9 +387,9 @@ func speedTest2(f serial.Port) {
 	sb[1] = byte('S')
 	sb[2] = byte('B')
 	sb[3] = byte('A')
-	sb[4] = byte(OpIOVM_EXEC)
-	sb[5] = byte(SpaceSNES)
-	sb[6] = byte(FlagDATA64B)
+	sb[4] = byte(usb2snes.OpIOVM_EXEC)
+	sb[5] = byte(usb2snes.SpaceSNES)
+	sb[6] = byte(usb2snes.FlagDATA64B)
 
 	b := sb[8:8:cap(sb)]
 	// wait until [$2C00] & $FF == 0:
@@ -339,21 +402,27 @@ func speedTest2(f serial.Port) {
 	sb[7] = byte(len(b))
 
 	const iterations = 1000
-	times := [iterations]float64{}
+	rt := run.NewTest("speedTest2", 0, iterations, map[string]interface{}{
+		"op":      "iovm_exec",
+		"program": hex.EncodeToString(sb[8 : 8+sb[7]]),
+	})
 
 	start := time.Now()
 	lastWrite := start
-	for i := 0; i < iterations; i++ {
+	i := 0
+	for ; i < iterations && !isInterrupted(); i++ {
 		// write:
 		lastWrite = time.Now()
 		n, err := f.Write(sb[:])
 		if err != nil {
 			log.Printf("write(): %v\n", err)
+			rt.Fail(i, err)
 			continue
 		}
 		// log.Printf("write(): wrote %d bytes\n", n)
 		if n != len(sb[:]) {
 			log.Printf("write(): expected to write 64 bytes but wrote %d\n", n)
+			rt.Fail(i, fmt.Errorf("short write of %d bytes", n))
 			continue
 		}
 
@@ -361,16 +430,20 @@ func speedTest2(f serial.Port) {
 		err = readChunk(f, tmp[:])
 		if err != nil {
 			log.Printf("readChunk(): %v\n", err)
+			rt.Fail(i, err)
 			continue
 		}
 		//log.Printf("VGET response:\n%s\n", hex.Dump(data))
 		//log.Printf("[$10] = $%02x; [$1A] = $%02x\n", data[0x10], data[0x1A])
 
-		times[i] = float64(time.Now().Sub(lastWrite).Nanoseconds())
+		rt.Record(i, time.Now().Sub(lastWrite))
 	}
+	rt.Truncate(i)
 
 	//end := time.Now()
 	//log.Printf("%#v ns total; %#v ns avg\n", end.Sub(start).Nanoseconds(), end.Sub(start).Nanoseconds() / iterations)
 
-	reportHistograms(times[:], p)
+	if err := bench.Report(log.Writer(), rt, outlierFilter, region, p); err != nil {
+		log.Println(err)
+	}
 }
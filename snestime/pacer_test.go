@@ -0,0 +1,113 @@
+package snestime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errStop = errors.New("stop")
+
+// runFrames runs p until fn returns false and returns every frame.
+func runFrames(t *testing.T, p *FramePacer, fn func(f Frame) bool) []Frame {
+	t.Helper()
+	var frames []Frame
+	err := p.Run(context.Background(), func(f Frame) error {
+		frames = append(frames, f)
+		if !fn(f) {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("Run = %v", err)
+	}
+	return frames
+}
+
+func TestPacerDeadlines(t *testing.T) {
+	timing := Timing{Region: NTSC}
+	p := NewFramePacer(timing, StrategySleep)
+	frames := runFrames(t, p, func(f Frame) bool { return f.N < 6 })
+
+	// every deadline is an exact offset from the first, whatever the lateness:
+	first := frames[0].Deadline
+	for i, f := range frames {
+		if f.N != uint64(i) || f.Missed != 0 {
+			t.Errorf("frame %d: N %d, missed %d", i, f.N, f.Missed)
+		}
+		want := first.Add(timing.FrameStart(f.N+1) - timing.FrameStart(1))
+		if !f.Deadline.Equal(want) {
+			t.Errorf("frame %d: deadline %v after the first, want %v", f.N, f.Deadline.Sub(first), want.Sub(first))
+		}
+		if f.At.Before(f.Deadline) {
+			t.Errorf("frame %d: started %v early", f.N, -f.Late())
+		}
+	}
+}
+
+func TestPacerSkipAhead(t *testing.T) {
+	timing := Timing{Region: NTSC}
+	p := NewFramePacer(timing, StrategySleep)
+	frames := runFrames(t, p, func(f Frame) bool {
+		if f.N == 1 {
+			// stall for five frames:
+			time.Sleep(5 * timing.AverageFrameDuration())
+		}
+		return f.N < 8
+	})
+
+	var stalled Frame
+	for i, f := range frames[1:] {
+		if frames[i].N == 1 {
+			stalled = f
+		}
+	}
+	if stalled.Missed < 3 {
+		t.Fatalf("frame after the stall missed %d frames, want at least 3", stalled.Missed)
+	}
+	if stalled.N != 2+stalled.Missed {
+		t.Errorf("frame after the stall is %d, want %d", stalled.N, 2+stalled.Missed)
+	}
+	// skipped frames keep the schedule and the pacer picks up the latest frame due:
+	want := frames[0].Deadline.Add(timing.FrameStart(stalled.N+1) - timing.FrameStart(1))
+	if !stalled.Deadline.Equal(want) {
+		t.Errorf("deadline after the stall off by %v", stalled.Deadline.Sub(want))
+	}
+	if late := stalled.Late(); late < 0 || late >= timing.FrameDuration(stalled.N+1) {
+		t.Errorf("frame after the stall is %v late, want less than a frame", late)
+	}
+}
+
+func TestPacerSteer(t *testing.T) {
+	const (
+		shift = 3 * time.Millisecond
+		rate  = 1e-3
+	)
+	timing := Timing{Region: PAL, Interlace: true}
+	p := NewFramePacer(timing, StrategySleep)
+
+	var steered Frame
+	frames := runFrames(t, p, func(f Frame) bool {
+		if f.N == 2 {
+			p.Steer(shift, rate)
+			steered = f
+		}
+		return f.N < 6
+	})
+
+	if p.Rate() != rate {
+		t.Errorf("Rate = %v, want %v", p.Rate(), rate)
+	}
+	for _, f := range frames {
+		if f.N <= steered.N {
+			continue
+		}
+		d := timing.FrameStart(f.N+1) - timing.FrameStart(steered.N+1)
+		want := steered.Deadline.Add(shift + time.Duration(float64(d)*(1+rate)))
+		if !f.Deadline.Equal(want) {
+			t.Errorf("frame %d: deadline off by %v", f.N, f.Deadline.Sub(want))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package snestime
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// PhaseLock is a phase-locked loop that steers a FramePacer onto the console's own
+// frames, which drift against the host clock. Feed it the host time of a console frame
+// edge now and then and it nudges the pacer so that each frame's deadline lands Target
+// after an edge.
+//
+// The first edge sets the phase outright. From then on the loop is a
+// proportional-integral filter on the phase error: Gain of the error is corrected at
+// once, and the rate that would make up FreqGain of it over the time
+// since the last measurement is added to the pacer's rate, which soaks up the steady
+// drift between the two clocks. Scaling by that time keeps the loop's response the
+// same however often edges are measured.
+type PhaseLock struct {
+	Pacer *FramePacer
+	// Target is how long after a console frame edge the pacer's deadlines should fall.
+	Target time.Duration
+	// Gain and FreqGain are the proportional and integral gains per measurement.
+	Gain     float64
+	FreqGain float64
+	// MaxRate bounds the rate correction; real clocks are within a few hundred ppm.
+	MaxRate float64
+
+	// last is the frame of the previous Update, if any.
+	last    uint64
+	started bool
+}
+
+// Default loop gains. Both modes of the loop are then real, so it settles within about
+// fifty measurements without ringing.
+const (
+	DefaultGain     = 0.5
+	DefaultFreqGain = 0.08
+	DefaultMaxRate  = 1e-3
+)
+
+// NewPhaseLock returns a loop that steers p so deadlines fall target after an edge.
+func NewPhaseLock(p *FramePacer, target time.Duration) *PhaseLock {
+	return &PhaseLock{
+		Pacer:    p,
+		Target:   target,
+		Gain:     DefaultGain,
+		FreqGain: DefaultFreqGain,
+		MaxRate:  DefaultMaxRate,
+	}
+}
+
+// Update takes an edge measured during or shortly before frame f and steers the pacer.
+// It returns the phase error: how far f's deadline was from Target after the nearest
+// edge, positive when late. Call it from the pacer's callback.
+func (l *PhaseLock) Update(f Frame, edge time.Time) time.Duration {
+	period := l.Pacer.Timing.AverageFrameDuration()
+	e := wrap(f.Deadline.Sub(edge)-l.Target, period)
+
+	// the initial error says nothing about drift, and integrating it would only wind the
+	// rate up, so the first edge is just jumped to:
+	shift, rate := -e, l.Pacer.Rate()
+	if l.started && f.N > l.last {
+		elapsed := l.Pacer.Timing.FrameStart(f.N) - l.Pacer.Timing.FrameStart(l.last)
+		shift = -time.Duration(l.Gain * float64(e))
+		rate -= l.FreqGain * float64(e) / float64(elapsed)
+		rate = math.Max(-l.MaxRate, math.Min(l.MaxRate, rate))
+	}
+	l.last, l.started = f.N, true
+
+	l.Pacer.Steer(shift, rate)
+	return e
+}
+
+// wrap reduces d into [-period/2, period/2) since edges repeat every period.
+func wrap(d, period time.Duration) time.Duration {
+	d %= period
+	if d >= period/2 {
+		d -= period
+	} else if d < -period/2 {
+		d += period
+	}
+	return d
+}
+
+// Edge is the host time of a console frame edge, or the error measuring it.
+type Edge struct {
+	At  time.Time
+	Err error
+}
+
+// SampleEdges calls measure in a new goroutine, waiting interval between calls, until
+// ctx is done. Measuring an edge can take a frame or more, so doing it in a FramePacer's
+// callback would make the pacer miss frames and skew the very phase it measures; take
+// edges from the returned channel there with a non-blocking receive instead. The
+// channel holds only one edge; newer ones are dropped until it is taken.
+func SampleEdges(ctx context.Context, interval time.Duration, measure func() (time.Time, error)) <-chan Edge {
+	ch := make(chan Edge, 1)
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			at, err := measure()
+			select {
+			case ch <- Edge{At: at, Err: err}:
+			default:
+			}
+
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,123 @@
+package snestime
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestPhaseLockConverges steps a pacer's schedule by hand against a simulated console
+// whose frames start offset from the pacer's and run ppm slower than the host's idea
+// of them, measuring an edge every few frames.
+func TestPhaseLockConverges(t *testing.T) {
+	tests := []struct {
+		offset time.Duration
+		ppm    float64
+		every  uint64
+	}{
+		{5 * time.Millisecond, 200, 10},
+		{-7 * time.Millisecond, -300, 10},
+		{0, 500, 10},
+		{3 * time.Millisecond, 150, 1},
+		{-2 * time.Millisecond, -400, 30},
+	}
+	for _, tt := range tests {
+		timing := Timing{Region: NTSC}
+		p := NewFramePacer(timing, StrategySleep)
+		l := NewPhaseLock(p, 2*time.Millisecond)
+		start := time.Unix(1000, 0)
+		p.anchor = start
+
+		first := start.Add(tt.offset - l.Target)
+		console := func(j uint64) time.Time {
+			return first.Add(time.Duration(float64(timing.FrameStart(j)) * (1 + tt.ppm*1e-6)))
+		}
+		want := tt.ppm * 1e-6
+
+		var errs []time.Duration
+		var rates []float64
+		for k := uint64(0); k < 60; k++ {
+			n := k*tt.every + 1
+			f := Frame{N: n - 1, Deadline: p.deadline(n)}
+			// the last console frame to start before the deadline:
+			j := uint64(f.Deadline.Sub(first) / timing.AverageFrameDuration())
+			for console(j).After(f.Deadline) {
+				j--
+			}
+			for !console(j + 1).After(f.Deadline) {
+				j++
+			}
+			edge := console(j)
+
+			errs = append(errs, l.Update(f, edge))
+			p.applySteer(n)
+			rates = append(rates, p.Rate())
+		}
+
+		// the first measurement sets the phase; the drift left over after it then
+		// shrinks without the error ever changing sign:
+		sign := errs[1] > 0
+		for k, e := range errs[1:] {
+			if abs(e) > 50*time.Nanosecond && (e > 0) != sign {
+				t.Errorf("%+v: phase error overshot to %v at measurement %d", tt, e, k+1)
+				break
+			}
+		}
+		if e := errs[len(errs)-1]; abs(e) > time.Microsecond {
+			t.Errorf("%+v: phase error still %v", tt, e)
+		}
+
+		// the rate approaches the drift from one side:
+		for k, r := range rates {
+			if math.Abs(r) > math.Abs(want)+1e-6 || r*want < 0 {
+				t.Errorf("%+v: rate %.1fppm at measurement %d overshoots %.1fppm", tt, r*1e6, k, want*1e6)
+				break
+			}
+		}
+		if r := rates[len(rates)-1]; math.Abs(r-want) > 1e-6 {
+			t.Errorf("%+v: rate %.1fppm, want %.1fppm", tt, r*1e6, want*1e6)
+		}
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func TestWrap(t *testing.T) {
+	const period = 100
+	for _, tt := range []struct{ d, want time.Duration }{
+		{0, 0},
+		{49, 49},
+		{50, -50},
+		{-50, -50},
+		{-51, 49},
+		{130, 30},
+		{-260, 40},
+	} {
+		if got := wrap(tt.d, period); got != tt.want {
+			t.Errorf("wrap(%d) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSampleEdges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	edges := SampleEdges(ctx, time.Millisecond, func() (time.Time, error) {
+		calls++
+		return time.Unix(int64(calls), 0), nil
+	})
+
+	e, ok := <-edges
+	if !ok || e.Err != nil || e.At.IsZero() {
+		t.Fatalf("first edge = %+v, %v", e, ok)
+	}
+	cancel()
+	for range edges {
+	}
+}
@@ -0,0 +1,88 @@
+// Package snestime converts between host time and SNES video frames.
+package snestime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Region is the video standard the console runs at.
+type Region int
+
+const (
+	NTSC Region = iota
+	PAL
+)
+
+// Master clock rates in Hz. NTSC is derived from the colour subcarrier as 1.89e9/88.
+const (
+	NTSCMasterClock = 1.89e9 / 88
+	PALMasterClock  = 21_281_370.0
+)
+
+// A scanline takes 1364 master clocks; see Timing for how many make up a frame.
+const scanlineClocks = 1364
+
+func (r Region) String() string {
+	switch r {
+	case NTSC:
+		return "ntsc"
+	case PAL:
+		return "pal"
+	default:
+		return fmt.Sprintf("Region(%d)", int(r))
+	}
+}
+
+// ParseRegion parses "ntsc" or "pal".
+func ParseRegion(s string) (Region, error) {
+	switch strings.ToLower(s) {
+	case "ntsc":
+		return NTSC, nil
+	case "pal":
+		return PAL, nil
+	default:
+		return NTSC, fmt.Errorf("unknown region %q (want ntsc or pal)", s)
+	}
+}
+
+// MarshalText writes the region the way ParseRegion reads it.
+func (r Region) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText parses a region written by MarshalText.
+func (r *Region) UnmarshalText(b []byte) (err error) {
+	*r, err = ParseRegion(string(b))
+	return
+}
+
+// MasterClock returns the master clock rate in Hz.
+func (r Region) MasterClock() float64 {
+	if r == PAL {
+		return PALMasterClock
+	}
+	return NTSCMasterClock
+}
+
+// FrameClocks returns the average length of a non-interlaced frame in master clocks:
+// 357366 for NTSC and 425568 for PAL.
+func (r Region) FrameClocks() float64 {
+	return Timing{Region: r}.AverageFrameClocks()
+}
+
+// FrameSeconds returns the average length of a frame in seconds.
+func (r Region) FrameSeconds() float64 {
+	return r.FrameClocks() / r.MasterClock()
+}
+
+// FrameDuration returns the average length of a frame rounded to the nanosecond.
+func (r Region) FrameDuration() time.Duration {
+	return time.Duration(r.FrameSeconds()*float64(time.Second) + 0.5)
+}
+
+// Frames returns d as a number of frames.
+func (r Region) Frames(d time.Duration) float64 {
+	return d.Seconds() / r.FrameSeconds()
+}
@@ -0,0 +1,110 @@
+package snestime
+
+import (
+	"math/bits"
+	"time"
+)
+
+// Timing models the exact length of every frame, which varies from one frame to the
+// next:
+//
+//   - A scanline is 1364 master clocks.
+//   - NTSC frames have 262 scanlines. Without interlace scanline 240 of every other
+//     frame is 4 clocks short; with interlace every other frame has a 263rd scanline.
+//   - PAL frames have 312 scanlines. With interlace every other frame has a 313th
+//     scanline and its scanline 311 is 4 clocks long.
+//
+// Frame 0 is the even field: the one without the short scanline or the extra one.
+type Timing struct {
+	Region    Region
+	Interlace bool
+}
+
+// Master clock periods as exact fractions of a nanosecond: 88/1.89 for NTSC and
+// 1e9/21281370 for PAL.
+var clockNS = [...]struct{ num, den uint64 }{
+	NTSC: {8800, 189},
+	PAL:  {100_000_000, 2_128_137},
+}
+
+// Scanlines returns how many scanlines frame n has.
+func (t Timing) Scanlines(n uint64) int {
+	lines := 262
+	if t.Region == PAL {
+		lines = 312
+	}
+	if t.Interlace && n%2 == 1 {
+		lines++
+	}
+	return lines
+}
+
+// FrameClocks returns the length of frame n in master clocks.
+func (t Timing) FrameClocks(n uint64) uint64 {
+	clocks := uint64(t.Scanlines(n)) * scanlineClocks
+	if n%2 == 1 {
+		switch {
+		case t.Region == NTSC && !t.Interlace:
+			clocks -= 4
+		case t.Region == PAL && t.Interlace:
+			clocks += 4
+		}
+	}
+	return clocks
+}
+
+// ClocksBefore returns the master clocks from the start of frame 0 to the start of
+// frame n.
+func (t Timing) ClocksBefore(n uint64) uint64 {
+	pair := t.FrameClocks(0) + t.FrameClocks(1)
+	clocks := n / 2 * pair
+	if n%2 == 1 {
+		clocks += t.FrameClocks(0)
+	}
+	return clocks
+}
+
+// AverageFrameClocks returns the long-run average length of a frame in master clocks.
+func (t Timing) AverageFrameClocks() float64 {
+	return float64(t.FrameClocks(0)+t.FrameClocks(1)) / 2
+}
+
+// FrameStart returns the time from the start of frame 0 to the start of frame n. It is
+// computed from the exact clock count each time so it does not drift however large n
+// gets.
+func (t Timing) FrameStart(n uint64) time.Duration {
+	return t.clocksToDuration(t.ClocksBefore(n))
+}
+
+// FrameDuration returns the length of frame n, rounded so that consecutive frames add
+// up to FrameStart.
+func (t Timing) FrameDuration(n uint64) time.Duration {
+	return t.FrameStart(n+1) - t.FrameStart(n)
+}
+
+// VBlankStart returns the time from the start of a frame to the start of vblank, when
+// the NMI fires: scanline 225, or 240 with overscan. Neither frame length quirk comes
+// before it.
+func (t Timing) VBlankStart(overscan bool) time.Duration {
+	line := uint64(225)
+	if overscan {
+		line = 240
+	}
+	return t.clocksToDuration(line * scanlineClocks)
+}
+
+// AverageFrameDuration returns the long-run average length of a frame.
+func (t Timing) AverageFrameDuration() time.Duration {
+	return time.Duration(t.AverageFrameClocks()/t.Region.MasterClock()*float64(time.Second) + 0.5)
+}
+
+// clocksToDuration converts master clocks to the nearest nanosecond with 128-bit
+// intermediates so large counts neither overflow nor lose precision.
+func (t Timing) clocksToDuration(clocks uint64) time.Duration {
+	c := clockNS[t.Region]
+	hi, lo := bits.Mul64(clocks, c.num)
+	lo, carry := bits.Add64(lo, c.den/2, 0)
+	hi += carry
+	q, _ := bits.Div64(hi, lo, c.den)
+	return time.Duration(q)
+}
@@ -0,0 +1,91 @@
+package snestime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimingKnownAnswers(t *testing.T) {
+	tests := []struct {
+		timing Timing
+		// clocks in frames 0 and 1
+		clocks [2]uint64
+		// durations of frame 0 and of frames 0 and 1 together
+		first, pair time.Duration
+		average     time.Duration
+		// FrameStart(2e11) and (2e11+1), whose clock counts times the clock period
+		// overflow 64 bits
+		far, farOdd time.Duration
+	}{
+		{
+			timing:  Timing{Region: NTSC},
+			clocks:  [2]uint64{357368, 357364},
+			first:   16639357,
+			pair:    33278527,
+			average: 16639263,
+			far:     3327852698412698413,
+			farOdd:  3327852698429337769,
+		},
+		{
+			timing:  Timing{Region: NTSC, Interlace: true},
+			clocks:  [2]uint64{357368, 358732},
+			first:   16639357,
+			pair:    33342222,
+			average: 16671111,
+			far:     3334222222222222222,
+			farOdd:  3334222222238861579,
+		},
+		{
+			timing:  Timing{Region: PAL},
+			clocks:  [2]uint64{425568, 425568},
+			first:   19997209,
+			pair:    39994418,
+			average: 19997209,
+			far:     3999441765262292794,
+			farOdd:  3999441765282290003,
+		},
+		{
+			timing:  Timing{Region: PAL, Interlace: true},
+			clocks:  [2]uint64{425568, 426936},
+			first:   19997209,
+			pair:    40058699,
+			average: 20029350,
+			far:     4005869922848012135,
+			farOdd:  4005869922868009343,
+		},
+	}
+	for _, tt := range tests {
+		name := tt.timing.Region.String()
+		if tt.timing.Interlace {
+			name += " interlace"
+		}
+
+		for n, want := range tt.clocks {
+			if got := tt.timing.FrameClocks(uint64(n)); got != want {
+				t.Errorf("%s: FrameClocks(%d) = %d, want %d", name, n, got, want)
+			}
+			// the pattern repeats every two frames:
+			if got := tt.timing.FrameClocks(uint64(n) + 1000); got != want {
+				t.Errorf("%s: FrameClocks(%d) = %d, want %d", name, n+1000, got, want)
+			}
+		}
+		if got := tt.timing.FrameStart(1); got != tt.first {
+			t.Errorf("%s: FrameStart(1) = %d, want %d", name, got, tt.first)
+		}
+		if got := tt.timing.FrameStart(2); got != tt.pair {
+			t.Errorf("%s: FrameStart(2) = %d, want %d", name, got, tt.pair)
+		}
+		if got := tt.timing.FrameDuration(0) + tt.timing.FrameDuration(1); got != tt.pair {
+			t.Errorf("%s: FrameDuration(0) + FrameDuration(1) = %d, want %d", name, got, tt.pair)
+		}
+		if got := tt.timing.AverageFrameDuration(); got != tt.average {
+			t.Errorf("%s: AverageFrameDuration = %d, want %d", name, got, tt.average)
+		}
+		if got := tt.timing.FrameStart(2e11); got != tt.far {
+			t.Errorf("%s: FrameStart(2e11) = %d, want %d", name, got, tt.far)
+		}
+		if got := tt.timing.FrameStart(2e11 + 1); got != tt.farOdd {
+			t.Errorf("%s: FrameStart(2e11+1) = %d, want %d", name, got, tt.farOdd)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sertest/bench"
+	"sertest/snestime"
+	"sertest/stats"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// jitterRun is the JSON written by the bench command.
+type jitterRun struct {
+	Tool      string          `json:"tool"`
+	Started   time.Time       `json:"started"`
+	Host      bench.Host      `json:"host"`
+	Region    snestime.Region `json:"region"`
+	Interlace bool            `json:"interlace"`
+	Frames    int             `json:"frames"`
+	Results   []*jitter       `json:"results"`
+}
+
+// jitter is how closely one strategy kept to the frame deadlines. Lateness is how long
+// after its deadline a frame started; negative lateness is early.
+type jitter struct {
+	Strategy snestime.Strategy `json:"strategy"`
+	// Missed counts deadlines skipped because the pacer fell a whole frame behind.
+	Missed  uint64 `json:"missed"`
+	EarlyNS int64  `json:"max_early_ns"`
+	LateNS  int64  `json:"max_late_ns"`
+	MeanNS  int64  `json:"mean_late_ns"`
+	StdDev  int64  `json:"stddev_ns"`
+	P99NS   int64  `json:"p99_abs_late_ns"`
+	// IntervalStdDev is the standard deviation of the time between frames from the
+	// exact frame lengths.
+	IntervalStdDev int64 `json:"interval_stddev_ns"`
+	// LatenessNS holds every frame's lateness.
+	LatenessNS []int64 `json:"lateness_ns"`
+}
+
+var errDone = errors.New("done")
+
+func benchStrategies(timing snestime.Timing, frames int, spinWindow time.Duration, args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	list := fs.String("strategies", "ticker,sleep,spin,hybrid", "comma-separated strategies to run")
+	jsonName := fs.String("json", "", "where to write the results (default <timestamp>.json)")
+	if err := fs.Parse(args); err != nil {
+		// fs has already printed the error and usage
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		log.Printf("unexpected arguments %q\n", fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+
+	var strategies []snestime.Strategy
+	for _, name := range strings.Split(*list, ",") {
+		s, err := snestime.ParseStrategy(strings.TrimSpace(name))
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		strategies = append(strategies, s)
+	}
+
+	r := &jitterRun{
+		Tool:      "snestiming",
+		Started:   time.Now().UTC(),
+		Host:      bench.LocalHost(),
+		Region:    timing.Region,
+		Interlace: timing.Interlace,
+		Frames:    frames,
+	}
+	for _, s := range strategies {
+		log.Printf("%s: pacing %d frames\n", s, frames)
+		pacer := snestime.NewFramePacer(timing, s)
+		pacer.SpinWindow = spinWindow
+		r.Results = append(r.Results, measureJitter(pacer, frames))
+	}
+
+	printJitter(r)
+
+	if *jsonName == "" {
+		*jsonName = timestamp() + ".json"
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(*jsonName, append(b, '\n'), 0644)
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	fmt.Printf("Results written to '%s'\n", *jsonName)
+	return exitOK
+}
+
+func measureJitter(pacer *snestime.FramePacer, frames int) *jitter {
+	j := &jitter{Strategy: pacer.Strategy}
+	var late, intervals []float64
+	var last snestime.Frame
+
+	_ = pacer.Run(context.Background(), func(f snestime.Frame) error {
+		l := f.Late()
+		j.Missed += f.Missed
+		j.LatenessNS = append(j.LatenessNS, l.Nanoseconds())
+		late = append(late, float64(l.Nanoseconds()))
+		if f.N > 0 && f.Missed == 0 {
+			// frame N is due at the start of frame N+1, so it follows frame N-1 by
+			// the length of frame N:
+			want := pacer.Timing.FrameDuration(f.N)
+			intervals = append(intervals, float64((f.At.Sub(last.At) - want).Nanoseconds()))
+		}
+		last = f
+		if len(late) >= frames {
+			return errDone
+		}
+		return nil
+	})
+
+	s := stats.Summarize(late, 0)
+	j.EarlyNS = int64(math.Max(0, -s.Min))
+	j.LateNS = int64(math.Max(0, s.Max))
+	j.MeanNS = int64(s.Mean)
+	j.StdDev = int64(s.StdDev)
+
+	abs := make([]float64, len(late))
+	for i, v := range late {
+		abs[i] = math.Abs(v)
+	}
+	j.P99NS = int64(stats.Percentile(stats.Sorted(abs), 99))
+	j.IntervalStdDev = int64(stats.Summarize(intervals, 0).StdDev)
+	return j
+}
+
+func printJitter(r *jitterRun) {
+	interlace := ""
+	if r.Interlace {
+		interlace = " interlaced"
+	}
+	fmt.Printf("\n%d %s%s frames on %s/%s:\n\n", r.Frames, r.Region, interlace, r.Host.OS, r.Host.Arch)
+
+	us := func(ns int64) string {
+		return fmt.Sprintf("%.1fµs", float64(ns)/1000)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "strategy\tmissed\tmax early\tmax late\tmean late\tstddev\tp99 |late|\tinterval stddev\t")
+	for _, j := range r.Results {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			j.Strategy,
+			j.Missed,
+			us(j.EarlyNS),
+			us(j.LateNS),
+			us(j.MeanNS),
+			us(j.StdDev),
+			us(j.P99NS),
+			us(j.IntervalStdDev),
+		)
+	}
+	w.Flush()
+	fmt.Println()
+}
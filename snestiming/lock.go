@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sertest/snestime"
+	"sertest/stats"
+	"sertest/usb2snes"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockToConsole phase-locks the pacer to the console's frames and reports the phase
+// error after every measurement.
+func lockToConsole(timing snestime.Timing, frames int, strategy snestime.Strategy, spinWindow time.Duration, args []string) int {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	portName := fs.String("port", "", "serial port of the FX Pak Pro (default: auto-detect)")
+	source := fs.String("source", "counter", "how to find frame edges: counter (VGET a frame counter) or nmi (the $2C00 NMI hook)")
+	counter := fs.String("counter", "F5001A", "hex address of the game's frame counter for -source counter")
+	counterSize := fs.Int("counter-size", 1, "size of the frame counter in bytes")
+	target := fs.Duration("target", 2*time.Millisecond, "where in the console's frame the pacer's deadlines should fall, after its start")
+	every := fs.Int("every", 10, "measure the edge about every this many frames")
+	overscan := fs.Bool("overscan", false, "the game runs with overscan, so vblank starts at line 240 rather than 225")
+	if err := fs.Parse(args); err != nil {
+		// fs has already printed the error and usage
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		log.Printf("unexpected arguments %q\n", fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+	if *every < 1 {
+		log.Println("-every must be at least 1")
+		return exitUsage
+	}
+	if strategy == snestime.StrategyTicker {
+		log.Println("the ticker strategy cannot be steered; pick another -strategy")
+		return exitUsage
+	}
+
+	// Both sources see the start of vblank: the NMI itself, or the game bumping its
+	// counter early in its NMI handler. edge turns that into the start of the frame.
+	vblank := timing.VBlankStart(*overscan)
+	var edge func(c *usb2snes.Conn) (time.Time, error)
+	switch *source {
+	case "counter":
+		addr, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(*counter), "0x"), 16, 24)
+		if err != nil {
+			log.Printf("bad counter address %q\n", *counter)
+			return exitUsage
+		}
+		if *counterSize < 1 || *counterSize > usb2snes.MaxVGETSize {
+			log.Printf("bad counter size %d\n", *counterSize)
+			return exitUsage
+		}
+		timeout := 3 * timing.AverageFrameDuration()
+		edge = func(c *usb2snes.Conn) (time.Time, error) {
+			at, err := c.CounterEdge(uint32(addr), *counterSize, timeout)
+			return at.Add(-vblank), err
+		}
+	case "nmi":
+		edge = func(c *usb2snes.Conn) (time.Time, error) {
+			at, err := c.NMIEdge()
+			return at.Add(-vblank), err
+		}
+	default:
+		log.Printf("unknown source %q\n", *source)
+		return exitUsage
+	}
+
+	c, err := usb2snes.OpenDevice(*portName)
+	if err != nil {
+		log.Println(err)
+		return exitNoDevice
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	pacer := snestime.NewFramePacer(timing, strategy)
+	pacer.SpinWindow = spinWindow
+	pll := snestime.NewPhaseLock(pacer, *target)
+
+	// measuring an edge holds the port for up to a few frames, so it runs beside the
+	// pacer rather than in its callback; c is only used by the sampler from here on
+	edges := snestime.SampleEdges(ctx, time.Duration(*every)*timing.AverageFrameDuration(), func() (time.Time, error) {
+		return edge(c)
+	})
+
+	log.Printf("%s: locking %s frames to %s edges + %v\n", c.Name, timing.Region, *source, *target)
+	var errs []float64
+	err = pacer.Run(ctx, func(f snestime.Frame) error {
+		if f.N >= uint64(frames) {
+			return errDone
+		}
+
+		var ed snestime.Edge
+		select {
+		case ed = <-edges:
+		default:
+			return nil
+		}
+		if errors.Is(ed.Err, usb2snes.ErrNoEdge) {
+			log.Printf("frame %d: %v\n", f.N, ed.Err)
+			return nil
+		}
+		if ed.Err != nil {
+			return ed.Err
+		}
+
+		e := pll.Update(f, ed.At)
+		errs = append(errs, float64(e.Nanoseconds()))
+		fmt.Printf("frame %6d  phase error %+9.1fµs  rate %+7.1fppm  late %+8.1fµs\n",
+			f.N,
+			float64(e.Nanoseconds())/1000,
+			pacer.Rate()*1e6,
+			float64(f.Late().Nanoseconds())/1000,
+		)
+		return nil
+	})
+	// let the sampler finish with c before it is closed
+	cancel()
+	for range edges {
+	}
+	if err != nil && err != errDone && err != context.Canceled {
+		log.Println(err)
+		return exitError
+	}
+
+	// the first half is the loop settling:
+	if settled := errs[len(errs)/2:]; len(settled) > 0 {
+		abs := make([]float64, len(settled))
+		for i, v := range settled {
+			abs[i] = math.Abs(v)
+		}
+		s := stats.Summarize(abs, 0)
+		log.Printf("settled phase error over %d measurements: median %.1fµs, p99 %.1fµs, max %.1fµs; rate %+.1fppm\n",
+			s.Count, s.Median/1000, s.P99/1000, s.Max/1000, pacer.Rate()*1e6)
+	}
+	return exitOK
+}
@@ -1,108 +1,103 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
-	"runtime"
+	"os"
+	"sertest/snestime"
+	"strings"
 	"time"
 )
 
-// SNES master clock ~= 1.89e9/88 Hz
-// SNES runs 1 scanline every 1364 master cycles
-// Frames are 262 scanlines in non-interlace mode (1 scanline takes 1360 clocks every other frame)
-// 1 frame takes 357366 master clocks
-const snes_frame_clocks = (261 * 1364) + 1362
-
-const snes_frame_nanoclocks = snes_frame_clocks * 1_000_000_000
-
-// 1 frame takes 0.016639356613757 seconds
-// 0.016639356613757 seconds = 16,639,356.613757 nanoseconds
-
-const snes_frame_time_nanoseconds_int = int(snes_frame_nanoclocks) / (int(1.89e9) / int(88))
-const snes_frame_time_nanoseconds_flt = snes_frame_nanoclocks / ((1.89e9) / 88)
-
-// This performs quite poorly in terms of scheduling jitter.
-func testChannelTicker() {
-	frameTicker := time.NewTicker(time.Nanosecond * time.Duration(snes_frame_time_nanoseconds_int))
-
-	// Test the ticker scheduler:
-	frames := 0
-	last_t := time.Now()
-mainloop:
-	for {
-		select {
-		case t := <-frameTicker.C:
-			frames++
-			//log.Printf("delta %v ns; %d frames remaining\n", t.Sub(last_t).Nanoseconds(), 600 - frames)
-			fmt.Printf("%v\n", t.Sub(last_t).Nanoseconds())
-			last_t = t
-			if frames >= 600 {
-				break mainloop
-			}
-		}
-	}
+// Exit codes:
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNoDevice = 3
+)
 
-	frameTicker.Stop()
+func usage() {
+	o := flag.CommandLine.Output()
+	fmt.Fprintf(o, "usage: snestiming [flags]                 print the time between paced frames, one per line\n")
+	fmt.Fprintf(o, "       snestiming [flags] bench [args]    compare the jitter of every strategy\n")
+	fmt.Fprintf(o, "       snestiming [flags] lock [args]     phase-lock to the console's frames and report the phase error\n\n")
+	fmt.Fprintf(o, "bench args:\n  -strategies list  comma-separated strategies to run (default all)\n  -json file        where to write the results (default <timestamp>.json)\n\n")
+	fmt.Fprintf(o, "lock args: see snestiming lock -h\n\nflags:\n")
+	flag.PrintDefaults()
 }
 
-// Tests performed on my MacBook Pro 2018 2.6 GHz Intel Core i7
-
-// Decent. This achieves +/- 47,840 ns [-9,612 .. +38,228] and all points are scattered about the range.
-func testSleepLoop() {
-	runtime.LockOSThread()
-
-	frames := 0
-	const dur = time.Nanosecond * time.Duration(snes_frame_time_nanoseconds_int)
-
-	last_t := time.Now()
-
-	const stepSize = 10_000
+func main() {
+	os.Exit(run())
+}
 
-	for frames < 600 {
-		frames++
+func run() int {
+	regionName := flag.String("region", "ntsc", "video standard to pace: ntsc or pal")
+	interlace := flag.Bool("interlace", false, "pace interlaced frames")
+	strategyName := flag.String("strategy", "spin", "how to wait for each frame: ticker, sleep, spin or hybrid")
+	frames := flag.Int("frames", 600, "number of frames to pace")
+	spinWindow := flag.Duration("spin-window", snestime.DefaultSpinWindow, "how long before each deadline the hybrid strategy starts spinning")
+	flag.Usage = usage
+	flag.Parse()
 
-		for time.Since(last_t) <= dur-(stepSize*2) {
-			time.Sleep(time.Duration(stepSize) * time.Nanosecond)
-		}
-		t := time.Now()
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
 
-		//log.Printf("delta %v ns; %d frames remaining\n", t.Sub(last_t).Nanoseconds(), 600 - frames)
-		fmt.Printf("%v\n", t.Sub(last_t).Nanoseconds())
-		last_t = t
+	region, err := snestime.ParseRegion(*regionName)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
 	}
+	if *frames < 2 {
+		log.Println("-frames must be at least 2")
+		return exitUsage
+	}
+	timing := snestime.Timing{Region: region, Interlace: *interlace}
 
-	runtime.UnlockOSThread()
-}
-
-// Excellent. This achieves +/- 49,882 ns [-152 .. +50,034] but all points are much closer to median.
-func testSpinLoop() {
-	runtime.LockOSThread()
+	strategy, err := snestime.ParseStrategy(*strategyName)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
 
-	frames := 0
-	const dur = time.Nanosecond * time.Duration(snes_frame_time_nanoseconds_int)
+	switch flag.Arg(0) {
+	case "":
+	case "bench":
+		return benchStrategies(timing, *frames, *spinWindow, flag.Args()[1:])
+	case "lock":
+		return lockToConsole(timing, *frames, strategy, *spinWindow, flag.Args()[1:])
+	default:
+		log.Printf("unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
 
-	last_t := time.Now()
+	log.Printf("SNES frames should take %v and %v ns\n", timing.FrameDuration(0).Nanoseconds(), timing.FrameDuration(1).Nanoseconds())
 
-	for frames < 600 {
-		frames++
+	pacer := snestime.NewFramePacer(timing, strategy)
+	pacer.SpinWindow = *spinWindow
 
-		for time.Since(last_t) < dur {
+	// print the time between frames, one per line:
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last snestime.Frame
+	for f := range pacer.Start(ctx) {
+		if f.N > 0 {
+			fmt.Printf("%v\n", f.At.Sub(last.At).Nanoseconds())
+		}
+		if f.Missed > 0 {
+			log.Printf("frame %d: missed %d frames\n", f.N, f.Missed)
+		}
+		last = f
+		if f.N+1 >= uint64(*frames) {
+			break
 		}
-		t := time.Now()
-
-		//log.Printf("delta %v ns; %d frames remaining\n", t.Sub(last_t).Nanoseconds(), 600 - frames)
-		fmt.Printf("%v\n", t.Sub(last_t).Nanoseconds())
-		last_t = t
 	}
-
-	runtime.UnlockOSThread()
+	return exitOK
 }
 
-func main() {
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
-	log.Printf("SNES frame should take %v ns\n", snes_frame_time_nanoseconds_int)
-
-	//testChannelTicker()
-	//testSleepLoop()
-	testSpinLoop()
+// timestamp names output files the same way as the other tools' logs.
+func timestamp() string {
+	return strings.ReplaceAll(time.Now().UTC().Format("2006-01-02T15-04-05.000000"), ".", "-")
 }
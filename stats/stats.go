@@ -0,0 +1,296 @@
+// Package stats summarizes latency samples and separates outliers from them.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Summary describes a set of latencies in nanoseconds.
+type Summary struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min_ns"`
+	Max    float64 `json:"max_ns"`
+	Mean   float64 `json:"mean_ns"`
+	Median float64 `json:"median_ns"`
+	P90    float64 `json:"p90_ns"`
+	P99    float64 `json:"p99_ns"`
+	P999   float64 `json:"p99_9_ns"`
+	StdDev float64 `json:"stddev_ns"`
+	// BytesPerSec is the payload per sample divided by the mean latency.
+	BytesPerSec float64 `json:"bytes_per_sec"`
+}
+
+// Summarize computes the summary of latencies in nanoseconds, each of which moved
+// bytes of payload. a is not modified.
+func Summarize(a []float64, bytes int) (s Summary) {
+	s.Count = len(a)
+	if len(a) == 0 {
+		return
+	}
+
+	sorted := Sorted(a)
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	s.Median = Percentile(sorted, 50)
+	s.P90 = Percentile(sorted, 90)
+	s.P99 = Percentile(sorted, 99)
+	s.P999 = Percentile(sorted, 99.9)
+
+	sum := 0.0
+	for _, v := range a {
+		sum += v
+	}
+	s.Mean = sum / float64(len(a))
+
+	if len(a) > 1 {
+		ss := 0.0
+		for _, v := range a {
+			ss += (v - s.Mean) * (v - s.Mean)
+		}
+		s.StdDev = math.Sqrt(ss / float64(len(a)-1))
+	}
+
+	if s.Mean > 0 {
+		s.BytesPerSec = float64(bytes) / (s.Mean / float64(time.Second))
+	}
+	return
+}
+
+// Sorted returns a sorted copy of a.
+func Sorted(a []float64) []float64 {
+	sorted := append([]float64(nil), a...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// Percentile returns the p-th percentile (0-100) of sorted, interpolating linearly
+// between the closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	if lo < 0 {
+		return sorted[0]
+	}
+	frac := rank - float64(lo)
+	return sorted[lo] + frac*(sorted[lo+1]-sorted[lo])
+}
+
+// OutlierMethod selects how Split decides that a sample is an outlier.
+type OutlierMethod int
+
+const (
+	// OutliersNone keeps every sample.
+	OutliersNone OutlierMethod = iota
+	// OutliersIQR drops samples more than K interquartile ranges outside the quartiles.
+	OutliersIQR
+	// OutliersMAD drops samples more than K scaled median absolute deviations from the
+	// median.
+	OutliersMAD
+	// OutliersFixed drops samples more than Threshold from the median.
+	OutliersFixed
+)
+
+var methodNames = [...]string{"none", "iqr", "mad", "fixed"}
+
+func (m OutlierMethod) String() string {
+	if int(m) < len(methodNames) {
+		return methodNames[m]
+	}
+	return fmt.Sprintf("OutlierMethod(%d)", int(m))
+}
+
+// Default multipliers: Tukey's fences and the usual modified z-score cut-off.
+const (
+	DefaultIQRK = 1.5
+	DefaultMADK = 3.5
+)
+
+// madScale turns the median absolute deviation into an estimate of the standard
+// deviation of normally distributed samples.
+const madScale = 1.4826
+
+// OutlierFilter is a configured outlier detection method.
+type OutlierFilter struct {
+	Method OutlierMethod
+	// K is the multiplier for OutliersIQR and OutliersMAD.
+	K float64
+	// Threshold is the allowed distance from the median for OutliersFixed.
+	Threshold time.Duration
+}
+
+// ParseOutlierFilter parses "none", "iqr[:k]", "mad[:k]" or "fixed:duration", e.g.
+// "iqr:3" or "fixed:10ms".
+func ParseOutlierFilter(s string) (OutlierFilter, error) {
+	name, arg := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+
+	f := OutlierFilter{}
+	switch name {
+	case "none":
+		if arg != "" {
+			return f, fmt.Errorf("outlier filter %q takes no argument", s)
+		}
+		return f, nil
+	case "iqr":
+		f.Method, f.K = OutliersIQR, DefaultIQRK
+	case "mad":
+		f.Method, f.K = OutliersMAD, DefaultMADK
+	case "fixed":
+		f.Method = OutliersFixed
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return f, fmt.Errorf("outlier filter %q needs a positive duration, e.g. fixed:10ms", s)
+		}
+		f.Threshold = d
+		return f, nil
+	default:
+		return f, fmt.Errorf("unknown outlier filter %q (want none, iqr[:k], mad[:k] or fixed:duration)", s)
+	}
+
+	if arg != "" {
+		k, err := strconv.ParseFloat(arg, 64)
+		if err != nil || k <= 0 {
+			return f, fmt.Errorf("outlier filter %q needs a positive multiplier", s)
+		}
+		f.K = k
+	}
+	return f, nil
+}
+
+func (f OutlierFilter) String() string {
+	switch f.Method {
+	case OutliersIQR, OutliersMAD:
+		return fmt.Sprintf("%s:%g", f.Method, f.K)
+	case OutliersFixed:
+		return fmt.Sprintf("%s:%v", f.Method, f.Threshold)
+	default:
+		return f.Method.String()
+	}
+}
+
+// MarshalText records the filter in results files in the form ParseOutlierFilter reads.
+func (f OutlierFilter) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText parses a filter written by MarshalText.
+func (f *OutlierFilter) UnmarshalText(b []byte) (err error) {
+	*f, err = ParseOutlierFilter(string(b))
+	return
+}
+
+// Split separates the samples in a into those kept and the indices into a of the
+// outliers.
+func (f OutlierFilter) Split(a []float64) (kept []float64, outliers []int) {
+	lo, hi := f.bounds(a)
+	kept = make([]float64, 0, len(a))
+	for i, v := range a {
+		if v < lo || v > hi {
+			outliers = append(outliers, i)
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return
+}
+
+// bounds returns the range of values that are not outliers.
+func (f OutlierFilter) bounds(a []float64) (lo, hi float64) {
+	lo, hi = math.Inf(-1), math.Inf(1)
+	if len(a) == 0 {
+		return
+	}
+
+	sorted := Sorted(a)
+	median := Percentile(sorted, 50)
+	switch f.Method {
+	case OutliersIQR:
+		q1, q3 := Percentile(sorted, 25), Percentile(sorted, 75)
+		iqr := q3 - q1
+		lo, hi = q1-f.K*iqr, q3+f.K*iqr
+	case OutliersMAD:
+		dev := make([]float64, len(sorted))
+		for i, v := range sorted {
+			dev[i] = math.Abs(v - median)
+		}
+		sort.Float64s(dev)
+		mad := Percentile(dev, 50) * madScale
+		if mad == 0 {
+			// more than half the samples are identical; nothing stands out:
+			return
+		}
+		lo, hi = median-f.K*mad, median+f.K*mad
+	case OutliersFixed:
+		t := float64(f.Threshold.Nanoseconds())
+		lo, hi = median-t, median+t
+	}
+	return
+}
+
+// MannWhitneyU tests whether a and b come from the same distribution without assuming
+// either is normal. It returns the U statistic of a and the two-sided p-value from the
+// normal approximation with a correction for ties, which is good for the sample sizes
+// a benchmark collects but not for a handful of samples.
+func MannWhitneyU(a, b []float64) (u, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if len(a) == 0 || len(b) == 0 {
+		return 0, 1
+	}
+
+	type sample struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// rank with ties sharing their average rank:
+	rankSumA, tieTerm := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	u = rankSumA - n1*(n1+1)/2
+	n := n1 + n2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		// every sample is the same value:
+		return u, 1
+	}
+
+	z := math.Abs(u-mean) / math.Sqrt(variance)
+	p = math.Erfc(z / math.Sqrt2)
+	return u, p
+}
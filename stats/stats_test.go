@@ -0,0 +1,156 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{[]float64{1, 2, 3, 4}, 0, 1},
+		{[]float64{1, 2, 3, 4}, 25, 1.75},
+		{[]float64{1, 2, 3, 4}, 50, 2.5},
+		{[]float64{1, 2, 3, 4}, 90, 3.7},
+		{[]float64{1, 2, 3, 4}, 100, 4},
+		{[]float64{10, 20}, 99.9, 19.99},
+		{[]float64{5}, 0, 5},
+		{[]float64{5}, 50, 5},
+		{[]float64{5}, 100, 5},
+	}
+	for _, tt := range tests {
+		if got := Percentile(tt.sorted, tt.p); !near(got, tt.want) {
+			t.Errorf("Percentile(%v, %g) = %g, want %g", tt.sorted, tt.p, got, tt.want)
+		}
+	}
+
+	if got := Percentile(nil, 50); !math.IsNaN(got) {
+		t.Errorf("Percentile(nil, 50) = %g, want NaN", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]float64{9, 4, 2, 4, 5, 4, 7, 5}, 1000)
+	if s.Count != 8 || s.Min != 2 || s.Max != 9 || s.Mean != 5 || s.Median != 4.5 {
+		t.Errorf("Summarize = %+v", s)
+	}
+	if !near(s.StdDev, 2.138089935299395) {
+		t.Errorf("StdDev = %g, want the sample standard deviation 2.1381", s.StdDev)
+	}
+	// 1000 bytes every 5ns:
+	if !near(s.BytesPerSec, 200e9) {
+		t.Errorf("BytesPerSec = %g, want 2e11", s.BytesPerSec)
+	}
+
+	one := Summarize([]float64{42}, 0)
+	want := Summary{Count: 1, Min: 42, Max: 42, Mean: 42, Median: 42, P90: 42, P99: 42, P999: 42}
+	if one != want {
+		t.Errorf("Summarize of one sample = %+v, want %+v", one, want)
+	}
+
+	if empty := Summarize(nil, 100); empty != (Summary{}) {
+		t.Errorf("Summarize(nil) = %+v, want the zero Summary", empty)
+	}
+}
+
+func TestOutlierFilterSplit(t *testing.T) {
+	tests := []struct {
+		filter       string
+		a            []float64
+		wantOutliers []int
+	}{
+		{"none", []float64{1, 2, 3, 4, 100}, nil},
+		// q1 2, q3 4: the fences are -1 and 7:
+		{"iqr", []float64{1, 2, 3, 100, 4}, []int{3}},
+		{"iqr:100", []float64{1, 2, 3, 100, 4}, nil},
+		// median 3, MAD 1 scaled to 1.4826:
+		{"mad", []float64{1, 2, 3, 4, 100}, []int{4}},
+		// more than half the samples are equal so the MAD is 0 and nothing is dropped:
+		{"mad", []float64{5, 5, 5, 5, 100}, nil},
+		{"mad", []float64{7, 7, 7}, nil},
+		{"iqr", []float64{7, 7, 7}, nil},
+		// median 104, so 94 to 114:
+		{"fixed:10ns", []float64{100, 105, 120, 95, 104}, []int{2}},
+		{"iqr", []float64{8}, nil},
+		{"iqr", nil, nil},
+	}
+	for _, tt := range tests {
+		f, err := ParseOutlierFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("ParseOutlierFilter(%q): %v", tt.filter, err)
+		}
+		kept, outliers := f.Split(tt.a)
+		if !reflect.DeepEqual(outliers, tt.wantOutliers) {
+			t.Errorf("%s.Split(%v) outliers = %v, want %v", tt.filter, tt.a, outliers, tt.wantOutliers)
+		}
+		if len(kept)+len(outliers) != len(tt.a) {
+			t.Errorf("%s.Split(%v) kept %d and dropped %d of %d", tt.filter, tt.a, len(kept), len(outliers), len(tt.a))
+		}
+	}
+}
+
+func TestParseOutlierFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want OutlierFilter
+		str  string
+	}{
+		{"none", OutlierFilter{}, "none"},
+		{"iqr", OutlierFilter{Method: OutliersIQR, K: DefaultIQRK}, "iqr:1.5"},
+		{"iqr:3", OutlierFilter{Method: OutliersIQR, K: 3}, "iqr:3"},
+		{"mad", OutlierFilter{Method: OutliersMAD, K: DefaultMADK}, "mad:3.5"},
+		{"fixed:10ms", OutlierFilter{Method: OutliersFixed, Threshold: 10 * time.Millisecond}, "fixed:10ms"},
+	}
+	for _, tt := range tests {
+		f, err := ParseOutlierFilter(tt.in)
+		if err != nil {
+			t.Errorf("ParseOutlierFilter(%q): %v", tt.in, err)
+			continue
+		}
+		if f != tt.want || f.String() != tt.str {
+			t.Errorf("ParseOutlierFilter(%q) = %+v (%s), want %+v (%s)", tt.in, f, f, tt.want, tt.str)
+		}
+		if again, err := ParseOutlierFilter(f.String()); err != nil || again != f {
+			t.Errorf("ParseOutlierFilter(%q) does not round-trip: %+v, %v", f.String(), again, err)
+		}
+	}
+
+	for _, in := range []string{"", "bogus", "none:1", "iqr:0", "mad:-1", "mad:x", "fixed", "fixed:0s"} {
+		if _, err := ParseOutlierFilter(in); err == nil {
+			t.Errorf("ParseOutlierFilter(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  []float64
+		wantU float64
+		wantP float64
+	}{
+		// no overlap, no ties:
+		{"separate", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0, 0.009023438818080334},
+		{"separate reversed", []float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 25, 0.009023438818080334},
+		// ranks 1, 2.5, 2.5, 4.5, 4.5, 6 with two tied pairs:
+		{"ties", []float64{1, 2, 3}, []float64{2, 3, 4}, 2, 0.26115455974183294},
+		// every sample tied: the variance is 0 and nothing can be told apart:
+		{"all equal", []float64{3, 3, 3}, []float64{3, 3, 3}, 4.5, 1},
+		{"empty", nil, []float64{1, 2}, 0, 1},
+	}
+	for _, tt := range tests {
+		u, p := MannWhitneyU(tt.a, tt.b)
+		if !near(u, tt.wantU) || !near(p, tt.wantP) {
+			t.Errorf("%s: MannWhitneyU = (%g, %g), want (%g, %g)", tt.name, u, p, tt.wantU, tt.wantP)
+		}
+	}
+}
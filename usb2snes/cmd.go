@@ -0,0 +1,54 @@
+package usb2snes
+
+import (
+	"errors"
+)
+
+// ErrCMDLocked is returned when SpaceCMD is accessed while the running ROM does not
+// report FeatCMD_UNLOCK.
+var ErrCMDLocked = errors.New("usb2snes: SpaceCMD is locked; running ROM lacks FeatCMD_UNLOCK")
+
+// CMDUnlocked reports whether the running ROM has SpaceCMD unlocked.
+func (c *Conn) CMDUnlocked() (bool, error) {
+	info, err := c.Info()
+	if err != nil {
+		return false, err
+	}
+	return info.Features&FeatCMD_UNLOCK != 0, nil
+}
+
+func (c *Conn) checkCMD() error {
+	unlocked, err := c.CMDUnlocked()
+	if err != nil {
+		return err
+	}
+	if !unlocked {
+		return ErrCMDLocked
+	}
+	return nil
+}
+
+// GetCMD reads size bytes at addr in SpaceCMD.
+func (c *Conn) GetCMD(addr uint32, size uint32) ([]byte, error) {
+	if err := c.checkCMD(); err != nil {
+		return nil, err
+	}
+	return c.Get(SpaceCMD, addr, size)
+}
+
+// PutCMD writes data at addr in SpaceCMD. flags may include FlagCLRX to clear the
+// execute flag before the data is written and FlagSETX to set it afterwards.
+func (c *Conn) PutCMD(addr uint32, data []byte, flags Flags) error {
+	if err := c.checkCMD(); err != nil {
+		return err
+	}
+	return c.put(SpaceCMD, addr, data, flags&(FlagCLRX|FlagSETX))
+}
+
+// ExecCMD uploads a 65816 routine to the start of the command region and has the
+// firmware's NMI hook run it on the next frame: the execute flag is cleared while the
+// code is written so a half-written routine never runs, and set once it is complete.
+// The routine must return the way the NMI hook expects, e.g. with `JMP ($FFEA)`.
+func (c *Conn) ExecCMD(code []byte) error {
+	return c.PutCMD(0, code, FlagCLRX|FlagSETX)
+}
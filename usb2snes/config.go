@@ -0,0 +1,117 @@
+package usb2snes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConfigSize is how much of SpaceCONFIG is read and written; the firmware's settings
+// block fits in a single 512-byte transfer.
+const ConfigSize = BlockSize
+
+type ConfigKind uint8
+
+const (
+	ConfigUint ConfigKind = iota
+	ConfigString
+)
+
+// ConfigField is a named setting at a known offset in SpaceCONFIG. Multi-byte integers
+// are little endian as the firmware runs on an ARM core.
+type ConfigField struct {
+	Name   string
+	Offset int
+	Size   int
+	Kind   ConfigKind
+}
+
+// ConfigFields is the part of the firmware's settings block whose layout is known. The
+// offsets follow the firmware's cfg_t struct but have not been checked against every
+// firmware release, which is why fxpakcfg dumps record the firmware they were read
+// from. The bytes in between and after are kept as is.
+var ConfigFields = []ConfigField{
+	{"vidmode_menu", 0, 1, ConfigUint},
+	{"vidmode_game", 1, 1, ConfigUint},
+	{"pair_mode_allowed", 2, 1, ConfigUint},
+	{"bsx_use_usertime", 3, 1, ConfigUint},
+	{"r213f_override", 16, 1, ConfigUint},
+	{"enable_irq_hook", 17, 1, ConfigUint},
+	{"enable_irq_buttons", 18, 1, ConfigUint},
+	{"enable_screensaver", 19, 1, ConfigUint},
+	{"screensaver_timeout", 20, 2, ConfigUint},
+	{"sort_directories", 22, 1, ConfigUint},
+	{"hide_extensions", 23, 1, ConfigUint},
+	{"cx4_speed", 24, 1, ConfigUint},
+	{"skin_name", 25, 128, ConfigString},
+	{"control_type", 153, 1, ConfigUint},
+	{"msu_volume_boost", 154, 1, ConfigUint},
+	{"onechip_transient_fixes", 155, 1, ConfigUint},
+	{"brightness_limit", 156, 1, ConfigUint},
+	{"gsu_speed", 157, 1, ConfigUint},
+	{"reset_to_menu", 158, 1, ConfigUint},
+	{"led_brightness", 159, 1, ConfigUint},
+	{"enable_cheats", 160, 1, ConfigUint},
+}
+
+// LookupConfigField finds a field of ConfigFields by name.
+func LookupConfigField(name string) (ConfigField, bool) {
+	for _, f := range ConfigFields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ConfigField{}, false
+}
+
+// Get formats the field's value in the settings block b.
+func (f ConfigField) Get(b []byte) string {
+	v := b[f.Offset : f.Offset+f.Size]
+	if f.Kind == ConfigString {
+		return cString(v)
+	}
+
+	n := uint64(0)
+	for i := len(v) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(v[i])
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// Set parses s and stores it as the field's value in the settings block b.
+func (f ConfigField) Set(b []byte, s string) error {
+	v := b[f.Offset : f.Offset+f.Size]
+	if f.Kind == ConfigString {
+		if len(s) >= f.Size {
+			return fmt.Errorf("config: %s: longer than %d characters", f.Name, f.Size-1)
+		}
+		for i := range v {
+			v[i] = 0
+		}
+		copy(v, s)
+		return nil
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 0, f.Size*8)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", f.Name, err)
+	}
+	for i := range v {
+		v[i] = byte(n)
+		n >>= 8
+	}
+	return nil
+}
+
+// ReadConfig reads the firmware's settings block from SpaceCONFIG.
+func (c *Conn) ReadConfig() ([]byte, error) {
+	return c.Get(SpaceCONFIG, 0, ConfigSize)
+}
+
+// WriteConfig writes a settings block to SpaceCONFIG and reads it back to verify it.
+func (c *Conn) WriteConfig(b []byte) error {
+	if len(b) != ConfigSize {
+		return fmt.Errorf("config: expected %d bytes, got %d", ConfigSize, len(b))
+	}
+	return c.PutVerify(SpaceCONFIG, 0, b)
+}
@@ -0,0 +1,241 @@
+package usb2snes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// DeviceSerial is the USB serial number reported by the FX Pak Pro.
+const DeviceSerial = "DEMO00000000"
+
+// Conn is an open connection to an FX Pak Pro.
+type Conn struct {
+	Name string
+
+	rw io.ReadWriteCloser
+	// sramProtected mirrors the last OpSRAM_ENABLE sent; see SRAMWritesEnabled.
+	sramProtected bool
+}
+
+var ErrNotFound = errors.New("usb2snes: no FX Pak Pro found")
+
+// NewConn wraps an already open transport, e.g. a serial port or an in-memory device.
+func NewConn(name string, rw io.ReadWriteCloser) *Conn {
+	return &Conn{Name: name, rw: rw}
+}
+
+// FindPort returns the name of the first serial port that belongs to an FX Pak Pro.
+func FindPort() (string, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return "", err
+	}
+
+	for _, port := range ports {
+		if !port.IsUSB {
+			continue
+		}
+		if port.SerialNumber == DeviceSerial {
+			return port.Name, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// Open opens the named serial port, trying the common baud rates in descending order,
+// and sets DTR on.
+func Open(portName string) (*Conn, error) {
+	bauds := []int{
+		921600,
+		460800,
+		256000,
+		230400,
+		153600,
+		128000,
+		115200,
+		76800,
+		57600,
+		38400,
+		28800,
+		19200,
+		14400,
+		9600,
+	}
+
+	var f serial.Port
+	var err error
+	for _, baud := range bauds {
+		f, err = serial.Open(portName, &serial.Mode{
+			BaudRate: baud,
+			DataBits: 8,
+			Parity:   serial.NoParity,
+			StopBits: serial.OneStopBit,
+		})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to open serial port at any baud rate: %w", portName, err)
+	}
+
+	if err = f.SetDTR(true); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: SetDTR: %w", portName, err)
+	}
+
+	return NewConn(portName, f), nil
+}
+
+// OpenDevice opens the named serial port or, if portName is empty, the first FX Pak Pro
+// found by FindPort.
+func OpenDevice(portName string) (*Conn, error) {
+	if portName == "" {
+		var err error
+		if portName, err = FindPort(); err != nil {
+			return nil, err
+		}
+	}
+	return Open(portName)
+}
+
+// Close sets DTR off if the transport is a serial port and closes it.
+func (c *Conn) Close() error {
+	if f, ok := c.rw.(serial.Port); ok {
+		_ = f.SetDTR(false)
+	}
+	return c.rw.Close()
+}
+
+// ReadWriter exposes the underlying transport for raw command timing.
+func (c *Conn) ReadWriter() io.ReadWriter {
+	return c.rw
+}
+
+// SetReadTimeout bounds how long a read waits for the device; 0 waits forever. It is a
+// no-op for transports other than serial ports.
+func (c *Conn) SetReadTimeout(t time.Duration) error {
+	f, ok := c.rw.(serial.Port)
+	if !ok {
+		return nil
+	}
+	if t == 0 {
+		t = serial.NoTimeout
+	}
+	return f.SetReadTimeout(t)
+}
+
+// drain discards whatever the device has left to send after an aborted command. It
+// only works with a read timeout set.
+func (c *Conn) drain() {
+	tmp := make([]byte, BlockSize)
+	for {
+		n, err := c.rw.Read(tmp)
+		if n == 0 || err != nil {
+			return
+		}
+	}
+}
+
+// ResponseError is returned when the device answers a command with its error flag set.
+type ResponseError struct {
+	Op   Opcode
+	Code byte
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("usb2snes: opcode %d failed with error %d", e.Op, e.Code)
+}
+
+func (c *Conn) readChunk(chunk []byte) (err error) {
+	n := 0
+	ns := 0
+	for ; ns < len(chunk); ns += n {
+		n, err = c.rw.Read(chunk[ns:])
+		if err != nil {
+			return fmt.Errorf("readChunk: error; read %d bytes: %w", ns+n, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("readChunk: expected to read %d bytes but read %d: %w", len(chunk), ns, io.ErrUnexpectedEOF)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) writeChunk(chunk []byte) error {
+	n, err := c.rw.Write(chunk)
+	if err != nil {
+		return fmt.Errorf("write(): %w", err)
+	}
+	if n != len(chunk) {
+		return fmt.Errorf("write(): expected to write %d bytes but wrote %d", len(chunk), n)
+	}
+	return nil
+}
+
+// command sends a command header and, unless FlagNORESP is set, reads and checks the
+// response header.
+func (c *Conn) command(sb []byte) (rsp []byte, err error) {
+	if err = c.writeChunk(sb); err != nil {
+		return
+	}
+
+	if Flags(sb[6])&FlagNORESP != 0 {
+		return
+	}
+
+	rsp = make([]byte, len(sb))
+	if err = c.readChunk(rsp); err != nil {
+		return
+	}
+	if string(rsp[0:4]) != "USBA" || Opcode(rsp[4]) != OpRESPONSE {
+		return rsp, fmt.Errorf("usb2snes: malformed response to opcode %d", sb[4])
+	}
+	if rsp[5] != 0 {
+		return rsp, &ResponseError{Op: Opcode(sb[4]), Code: rsp[5]}
+	}
+	return
+}
+
+// readData reads size bytes of payload that is padded out to whole blocks.
+func (c *Conn) readData(size int, block int) ([]byte, error) {
+	tmp := make([]byte, PaddedSize(size, block))
+	if err := c.readChunk(tmp); err != nil {
+		return nil, err
+	}
+	return tmp[:size], nil
+}
+
+// writeData writes data padded out to whole blocks.
+func (c *Conn) writeData(data []byte, block int) error {
+	for len(data) >= block {
+		if err := c.writeChunk(data[:block]); err != nil {
+			return err
+		}
+		data = data[block:]
+	}
+	if len(data) > 0 {
+		tmp := make([]byte, block)
+		copy(tmp, data)
+		if err := c.writeChunk(tmp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cString decodes a NUL-terminated string from b.
+func cString(b []byte) string {
+	s := string(b)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
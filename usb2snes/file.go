@@ -0,0 +1,205 @@
+package usb2snes
+
+import (
+	"fmt"
+	"io"
+)
+
+// DirEntry is one entry of an LS listing.
+type DirEntry struct {
+	Type FileType
+	Name string
+}
+
+func (e DirEntry) IsDir() bool {
+	return e.Type == FtDIRECTORY
+}
+
+// Progress is called after each block of a file transfer with the number of bytes
+// transferred so far and the total size.
+type Progress func(done, total int)
+
+// Longest paths that fit in the command header arguments along with their NUL
+// terminators; the second argument ends where the size field begins.
+const (
+	maxPathLen    = BlockSize - 256 - 1
+	maxNewPathLen = 252 - 8 - 1
+)
+
+// makeFileCmd builds a SpaceFILE command with path as its first argument at offset 256
+// and, for MV, newPath as its second argument at offset 8.
+func makeFileCmd(op Opcode, path string, size uint32, newPath string) ([]byte, error) {
+	if len(path) > maxPathLen {
+		return nil, fmt.Errorf("usb2snes: path too long (%d > %d): %q", len(path), maxPathLen, path)
+	}
+	if len(newPath) > maxNewPathLen {
+		return nil, fmt.Errorf("usb2snes: path too long (%d > %d): %q", len(newPath), maxNewPathLen, newPath)
+	}
+
+	sb := makeHeader(op, SpaceFILE, FlagNONE)
+	// size:
+	putUint32(sb[252:], size)
+	// name:
+	copy(sb[256:], path)
+	// second name:
+	copy(sb[8:], newPath)
+	return sb, nil
+}
+
+func (c *Conn) fileCommand(op Opcode, path string, size uint32, newPath string) ([]byte, error) {
+	sb, err := makeFileCmd(op, path, size, newPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.command(sb)
+}
+
+// List returns the entries of the directory at path.
+func (c *Conn) List(path string) (entries []DirEntry, err error) {
+	if _, err = c.fileCommand(OpLS, path, 0, ""); err != nil {
+		return
+	}
+
+	// the listing follows in 512-byte blocks; each entry is a type byte followed by a
+	// NUL-terminated name. A type of $02 continues the listing in the next block and
+	// $FF terminates it.
+	block := make([]byte, BlockSize)
+	for {
+		if err = c.readChunk(block); err != nil {
+			return
+		}
+
+		for i := 0; i < len(block); {
+			t := block[i]
+			if t == 0xFF {
+				return
+			}
+			if t == 0x02 {
+				break
+			}
+
+			i++
+			name := cString(block[i:])
+			i += len(name) + 1
+			entries = append(entries, DirEntry{Type: FileType(t), Name: name})
+		}
+	}
+}
+
+// GetFile downloads the file at path into w and returns its size.
+func (c *Conn) GetFile(path string, w io.Writer, progress Progress) (int, error) {
+	rsp, err := c.fileCommand(OpGET, path, 0, "")
+	if err != nil {
+		return 0, err
+	}
+
+	size := int(getUint32(rsp[252:]))
+	block := make([]byte, BlockSize)
+	for done := 0; done < size; {
+		if err = c.readChunk(block); err != nil {
+			return done, err
+		}
+
+		n := size - done
+		if n > BlockSize {
+			n = BlockSize
+		}
+		if _, err = w.Write(block[:n]); err != nil {
+			// keep draining so the device is left in a sane state:
+			_ = c.skip(size-done-n, block)
+			return done, err
+		}
+		done += n
+
+		if progress != nil {
+			progress(done, size)
+		}
+	}
+
+	return size, nil
+}
+
+// FileSize returns the size of the file at path. The LS reply carries no sizes, so this
+// sends a GET and takes the size from its response header. The firmware cannot cancel a
+// GET, so the contents still follow; they are discarded block by block as they arrive.
+func (c *Conn) FileSize(path string) (int, error) {
+	rsp, err := c.fileCommand(OpGET, path, 0, "")
+	if err != nil {
+		return 0, err
+	}
+
+	size := int(getUint32(rsp[252:]))
+	if err = c.skip(size, make([]byte, BlockSize)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// skip reads and discards size bytes of payload padded out to whole blocks of
+// len(block).
+func (c *Conn) skip(size int, block []byte) error {
+	for done := 0; done < size; done += len(block) {
+		if err := c.readChunk(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutFile uploads size bytes read from r to the file at path.
+func (c *Conn) PutFile(path string, r io.Reader, size int, progress Progress) error {
+	if _, err := c.fileCommand(OpPUT, path, uint32(size), ""); err != nil {
+		return err
+	}
+
+	block := make([]byte, BlockSize)
+	for done := 0; done < size; {
+		n := size - done
+		if n > BlockSize {
+			n = BlockSize
+		}
+		for i := range block[n:] {
+			block[n+i] = 0
+		}
+
+		var err error
+		if _, err = io.ReadFull(r, block[:n]); err != nil {
+			// the device still expects the rest of the file:
+			for i := range block {
+				block[i] = 0
+			}
+			for ; done < size; done += BlockSize {
+				_ = c.writeChunk(block)
+			}
+			return err
+		}
+		if err = c.writeChunk(block); err != nil {
+			return err
+		}
+		done += n
+
+		if progress != nil {
+			progress(done, size)
+		}
+	}
+
+	return nil
+}
+
+// Mkdir creates the directory at path.
+func (c *Conn) Mkdir(path string) error {
+	_, err := c.fileCommand(OpMKDIR, path, 0, "")
+	return err
+}
+
+// Remove deletes the file or empty directory at path.
+func (c *Conn) Remove(path string) error {
+	_, err := c.fileCommand(OpRM, path, 0, "")
+	return err
+}
+
+// Rename moves the file or directory at path to newPath.
+func (c *Conn) Rename(path string, newPath string) error {
+	_, err := c.fileCommand(OpMV, path, 0, newPath)
+	return err
+}
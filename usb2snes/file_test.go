@@ -0,0 +1,91 @@
+package usb2snes
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	d := NewMemDevice()
+	c := NewConn("mem", d)
+
+	if err := c.Mkdir("/roms"); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	// sizes on both sides of the 512-byte block boundary:
+	files := map[string][]byte{
+		"/roms/empty.sfc": {},
+		"/roms/small.sfc": []byte("hello"),
+		"/roms/block.sfc": bytes.Repeat([]byte{0xA5}, BlockSize),
+		"/roms/large.sfc": bytes.Repeat([]byte{1, 2, 3}, 1000),
+	}
+	for name, data := range files {
+		if err := c.PutFile(name, bytes.NewReader(data), len(data), nil); err != nil {
+			t.Fatalf("PutFile(%s): %v", name, err)
+		}
+	}
+
+	entries, err := c.List("/roms")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := map[string]FileType{}
+	for _, e := range entries {
+		got[e.Name] = e.Type
+	}
+	want := map[string]FileType{".": FtDIRECTORY, "..": FtDIRECTORY}
+	for name := range files {
+		want[name[len("/roms/"):]] = FtFILE
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+
+	for name, data := range files {
+		var buf bytes.Buffer
+		n, err := c.GetFile(name, &buf, nil)
+		if err != nil {
+			t.Fatalf("GetFile(%s): %v", name, err)
+		}
+		if n != len(data) || !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("GetFile(%s) = %d bytes, want %d", name, n, len(data))
+		}
+
+		size, err := c.FileSize(name)
+		if err != nil {
+			t.Fatalf("FileSize(%s): %v", name, err)
+		}
+		if size != len(data) {
+			t.Errorf("FileSize(%s) = %d, want %d", name, size, len(data))
+		}
+	}
+
+	if err := c.Rename("/roms/small.sfc", "/roms/moved.sfc"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	for name := range files {
+		if name == "/roms/small.sfc" {
+			name = "/roms/moved.sfc"
+		}
+		if err := c.Remove(name); err != nil {
+			t.Fatalf("Remove(%s): %v", name, err)
+		}
+	}
+	if err := c.Remove("/roms"); err != nil {
+		t.Fatalf("Remove(/roms): %v", err)
+	}
+
+	if _, err := c.GetFile("/roms/small.sfc", &bytes.Buffer{}, nil); err == nil {
+		t.Error("GetFile of a removed file succeeded")
+	}
+	if entries, err = c.List("/"); err != nil {
+		t.Fatalf("List(/): %v", err)
+	}
+	for _, e := range entries {
+		if e.Name == "roms" {
+			t.Error("/roms still listed after Remove")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package usb2snes
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoEdge is returned when no frame edge was seen in time, e.g. because the game is
+// paused on a lag frame or its frame counter is elsewhere.
+var ErrNoEdge = errors.New("usb2snes: no frame edge seen")
+
+// The edge estimates below are the host time of a fixed point in the console's frame,
+// give or take the USB latency. That latency is roughly constant so a caller that
+// locks onto a phase relative to these edges is not thrown off by it. Both take up to a
+// frame or more, so run them off the thread that paces frames.
+
+// FindEdge calls read back to back until its result changes and returns the midpoint
+// between the last read that saw the old value and the first that saw the new one. Each
+// read is timed at the midpoint of its call. It gives up with ErrNoEdge after timeout.
+// read is usually a VGet of a frame counter, as in CounterEdge, but can take a lock
+// around it to share the Conn.
+func FindEdge(read func() ([]byte, error), timeout time.Duration) (time.Time, error) {
+	sample := func() (string, time.Time, error) {
+		start := time.Now()
+		b, err := read()
+		end := time.Now()
+		return string(b), start.Add(end.Sub(start) / 2), err
+	}
+
+	last, lastAt, err := sample()
+	if err != nil {
+		return time.Time{}, err
+	}
+	deadline := lastAt.Add(timeout)
+	for lastAt.Before(deadline) {
+		v, at, err := sample()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if v != last {
+			return lastAt.Add(at.Sub(lastAt) / 2), nil
+		}
+		lastAt = at
+	}
+	return time.Time{}, ErrNoEdge
+}
+
+// CounterEdge finds the edge where the size-byte frame counter at addr changes. Games
+// usually bump their counter in the NMI handler, so the edge is in vblank rather than
+// at the start of the frame.
+func (c *Conn) CounterEdge(addr uint32, size int, timeout time.Duration) (time.Time, error) {
+	return FindEdge(func() ([]byte, error) {
+		return c.VGet(addr, size)
+	}, timeout)
+}
+
+// nmiHandshake is an IOVM program that waits until [$2C00] is 0, i.e. the routine
+// planted there by the previous run has been executed by the NMI handler, and then
+// plants it again: `STZ $2C00; JMP ($FFEA)`.
+var nmiHandshake = []byte{
+	// wait until [$2C00] & $FF == 0:
+	0x02, 0x05, 0x00, 0x00, 0x00, 0x00, 0xFF,
+	// write to $2C00: `STZ $2C00; JMP ($FFEA)`
+	0x01, 0x05, 0x00, 0x00, 0x00,
+	0x06, 0x9C, 0x00, 0x2C, 0x6C, 0xEA, 0xFF,
+}
+
+// NMIEdge uses the $2C00 NMI hook: it plants a routine that the next NMI runs and
+// clears, then waits for that to happen. The reply to the second IOVM_EXEC arrives just
+// after the NMI, which is when NMIEdge returns. That is the start of vblank, not of the
+// frame; subtract snestime's Timing.VBlankStart for the latter. The ROM must leave the
+// FX Pak Pro's NMI hook enabled.
+func (c *Conn) NMIEdge() (time.Time, error) {
+	sb := makeHeader(OpIOVM_EXEC, SpaceSNES, FlagDATA64B)
+	sb[7] = byte(len(nmiHandshake))
+	copy(sb[8:], nmiHandshake)
+
+	rsp := make([]byte, Block64Size)
+	for i := 0; i < 2; i++ {
+		if err := c.writeChunk(sb); err != nil {
+			return time.Time{}, err
+		}
+		if err := c.readChunk(rsp); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Now(), nil
+}
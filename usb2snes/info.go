@@ -0,0 +1,144 @@
+package usb2snes
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Info is the device's reply to OpINFO.
+type Info struct {
+	// FirmwareVersion is the numeric firmware version.
+	FirmwareVersion uint32
+	// Version is the firmware version string.
+	Version string
+	// ROM is the path of the running ROM, or the menu's when no game is loaded.
+	ROM string
+	// Features are the enhancement chip and USB features the running ROM uses.
+	Features InfoFlags
+}
+
+// Info queries the firmware version and the running ROM. All of it comes back in the
+// response header: the features in byte 6, the ROM name from byte 16, the numeric
+// version at 256 and the version string from 260.
+func (c *Conn) Info() (info Info, err error) {
+	rsp, err := c.command(makeHeader(OpINFO, SpaceSNES, FlagNONE))
+	if err != nil {
+		return
+	}
+
+	info.Features = InfoFlags(rsp[6])
+	info.ROM = cString(rsp[16:256])
+	info.FirmwareVersion = getUint32(rsp[256:])
+	info.Version = cString(rsp[260:])
+	return
+}
+
+// Boot checks that the ROM file at romPath exists, boots it and then polls OpINFO until
+// the device reports it running or timeout expires.
+func (c *Conn) Boot(romPath string, timeout time.Duration) error {
+	romPath = path.Clean("/" + romPath)
+
+	if err := c.checkFile(romPath); err != nil {
+		return err
+	}
+
+	// rebooting the running ROM only counts once the device has been seen loading it:
+	info, err := c.Info()
+	rebooting := err == nil && SameROM(info.ROM, romPath)
+
+	if _, err := c.fileCommand(OpBOOT, romPath, 0, ""); err != nil {
+		return fmt.Errorf("boot %s: %w", romPath, err)
+	}
+
+	return c.waitForROM(romPath, timeout, rebooting)
+}
+
+// checkFile verifies with an LS of its parent directory that p is an existing file.
+func (c *Conn) checkFile(p string) error {
+	dir, name := path.Split(p)
+	entries, err := c.List(dir)
+	if err != nil {
+		return fmt.Errorf("ls %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		if e.Name != name {
+			continue
+		}
+		if e.IsDir() {
+			return fmt.Errorf("%s: is a directory", p)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: no such file", p)
+}
+
+// WaitForROM polls OpINFO every 100ms until the running ROM is romPath or timeout
+// expires. The device may not answer while it loads the ROM so failed polls are
+// retried. If romPath was already running, use Boot instead, which also waits for the
+// reboot itself.
+func (c *Conn) WaitForROM(romPath string, timeout time.Duration) error {
+	return c.waitForROM(romPath, timeout, false)
+}
+
+// waitForROM is WaitForROM; with rebooting set it only accepts romPath after a poll
+// went unanswered or reported another ROM, i.e. the menu loading it.
+func (c *Conn) waitForROM(romPath string, timeout time.Duration, rebooting bool) error {
+	const interval = 100 * time.Millisecond
+
+	_ = c.SetReadTimeout(interval)
+	defer c.SetReadTimeout(0)
+
+	deadline := time.Now().Add(timeout)
+	last := ""
+	for {
+		info, err := c.Info()
+		if err == nil {
+			if SameROM(info.ROM, romPath) && !rebooting {
+				return nil
+			}
+			if !SameROM(info.ROM, romPath) {
+				rebooting = false
+			}
+			last = info.ROM
+		} else {
+			rebooting = false
+		}
+
+		if time.Now().After(deadline) {
+			if rebooting {
+				return fmt.Errorf("timed out waiting for %s to reboot; it never stopped running", romPath)
+			}
+			if err != nil {
+				return fmt.Errorf("timed out waiting for %s to boot: %w", romPath, err)
+			}
+			return fmt.Errorf("timed out waiting for %s to boot; running %q", romPath, last)
+		}
+
+		c.drain()
+		time.Sleep(interval)
+	}
+}
+
+// MenuROM is the ROM OpINFO reports while the FX Pak Pro's menu is running.
+const MenuROM = "/sd2snes/m3nu.bin"
+
+// IsMenu reports whether the ROM name reported by OpINFO is the menu.
+func IsMenu(reported string) bool {
+	return SameROM(reported, MenuROM)
+}
+
+// SameROM reports whether the ROM name reported by OpINFO refers to romPath. Depending
+// on the firmware the name is either the full path or just the file name.
+func SameROM(reported string, romPath string) bool {
+	if reported == "" {
+		return false
+	}
+	reported = strings.TrimPrefix(reported, "/")
+	romPath = strings.TrimPrefix(romPath, "/")
+	if strings.EqualFold(reported, romPath) {
+		return true
+	}
+	return !strings.Contains(reported, "/") && strings.EqualFold(reported, path.Base(romPath))
+}
@@ -0,0 +1,460 @@
+package usb2snes
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemDevice is an in-memory stand-in for an FX Pak Pro so tools can be exercised without
+// a cart. It answers:
+//   - the SpaceFILE LS, GET, PUT, MKDIR, RM and MV commands from Files and Dirs,
+//   - GET, PUT and VGET in the memory spaces from Mem,
+//   - OpINFO, OpBOOT and the resets from Info; the first OpINFO after a boot reports
+//     the menu,
+//   - OpTIME from ClockOffset,
+//   - OpSRAM_ENABLE from SRAMProtected and OpSRAM_WRITE into Mem,
+//   - OpSTREAM from Stream.
+//
+// Script the card's contents by filling the exported fields before use; wrap it with
+// NewConn.
+type MemDevice struct {
+	mu sync.Mutex
+
+	// Files maps absolute paths to file contents.
+	Files map[string][]byte
+	// Dirs holds the absolute paths of all directories; "/" is always present.
+	Dirs map[string]bool
+	// Mem holds the contents of each memory space, allocated on first use.
+	Mem map[Space][]byte
+
+	// Info is returned by OpINFO; OpBOOT sets its ROM.
+	Info Info
+	// ClockOffset is how far the emulated RTC is ahead of the host's clock.
+	ClockOffset time.Duration
+	// SRAMProtected is set while OpSRAM_ENABLE has SRAM writes disabled.
+	SRAMProtected bool
+	// Stream is sent in full when OpSTREAM starts.
+	Stream []StreamRecord
+
+	in   []byte
+	out  bytes.Buffer
+	recv *memRecv
+	// booting is the ROM OpINFO reports after the next one, which still shows the menu.
+	booting string
+}
+
+// memRecv tracks the payload of a PUT that is still being received.
+type memRecv struct {
+	size   int
+	padded int
+	buf    []byte
+	done   func(data []byte)
+}
+
+// memSpaceSize is the size of each emulated memory space.
+const memSpaceSize = 0x1000000
+
+func NewMemDevice() *MemDevice {
+	return &MemDevice{
+		Files: map[string][]byte{},
+		Dirs:  map[string]bool{"/": true},
+		Mem:   map[Space][]byte{},
+		Info: Info{
+			FirmwareVersion: 11,
+			Version:         "1.11.0",
+			ROM:             MenuROM,
+		},
+	}
+}
+
+// Read returns pending response bytes; it returns io.EOF rather than blocking when the
+// device has nothing more to say.
+func (d *MemDevice) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return d.out.Read(p)
+}
+
+func (d *MemDevice) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.in = append(d.in, p...)
+	for d.step() {
+	}
+	return len(p), nil
+}
+
+func (d *MemDevice) Close() error {
+	return nil
+}
+
+// step consumes one complete command or payload chunk from the input buffer and
+// reports whether it made progress.
+func (d *MemDevice) step() bool {
+	if d.recv != nil {
+		if len(d.in) == 0 {
+			return false
+		}
+		n := d.recv.padded - len(d.recv.buf)
+		if n > len(d.in) {
+			n = len(d.in)
+		}
+		d.recv.buf = append(d.recv.buf, d.in[:n]...)
+		d.in = d.in[n:]
+		if len(d.recv.buf) == d.recv.padded {
+			r := d.recv
+			d.recv = nil
+			r.done(r.buf[:r.size])
+		}
+		return true
+	}
+
+	if len(d.in) < 7 {
+		return false
+	}
+	n := BlockSize
+	if Flags(d.in[6])&FlagDATA64B != 0 {
+		n = Block64Size
+	}
+	if len(d.in) < n {
+		return false
+	}
+
+	cmd := append([]byte(nil), d.in[:n]...)
+	d.in = d.in[n:]
+	d.handle(cmd)
+	return true
+}
+
+// respond queues a response header unless the command asked for none.
+func (d *MemDevice) respond(cmd []byte, ok bool, size uint32) {
+	if Flags(cmd[6])&FlagNORESP != 0 {
+		return
+	}
+	// byte 5 of a response is its error code rather than the space:
+	rsp := makeHeader(OpRESPONSE, 0, Flags(cmd[6])&FlagDATA64B)
+	if !ok {
+		rsp[5] = 1
+	}
+	if len(rsp) == BlockSize {
+		putUint32(rsp[252:], size)
+	}
+	d.out.Write(rsp)
+}
+
+func (d *MemDevice) respondInfo(cmd []byte) {
+	rsp := makeHeader(OpRESPONSE, 0, FlagNONE)
+	rsp[6] = byte(d.Info.Features)
+	copy(rsp[16:255], d.Info.ROM)
+	putUint32(rsp[256:], d.Info.FirmwareVersion)
+	copy(rsp[260:511], d.Info.Version)
+	d.out.Write(rsp)
+}
+
+// sendStream queues records in stream packets; with no records it queues the end of
+// stream packet.
+func (d *MemDevice) sendStream(records []StreamRecord) {
+	const perPacket = Block64Size / streamRecordSize
+	for i := 0; i < len(records) || (len(records) == 0 && i == 0); i += perPacket {
+		packet := bytes.Repeat([]byte{0xFF}, Block64Size)
+		for j := 0; j < perPacket && i+j < len(records); j++ {
+			r := records[i+j]
+			b := packet[j*streamRecordSize:]
+			b[0], b[1], b[2], b[3] = byte(r.Addr>>16), byte(r.Addr>>8), byte(r.Addr), r.Value
+		}
+		d.out.Write(packet)
+	}
+}
+
+// send queues data padded out to whole blocks.
+func (d *MemDevice) send(data []byte, block int) {
+	d.out.Write(data)
+	d.out.Write(make([]byte, PaddedSize(len(data), block)-len(data)))
+}
+
+// receive arranges for the next size bytes of payload, padded out to whole blocks, to
+// be passed to done.
+func (d *MemDevice) receive(size int, block int, done func(data []byte)) {
+	if size == 0 {
+		done(nil)
+		return
+	}
+	d.recv = &memRecv{size: size, padded: PaddedSize(size, block), done: done}
+}
+
+func (d *MemDevice) handle(cmd []byte) {
+	op, space := Opcode(cmd[4]), Space(cmd[5])
+	switch op {
+	case OpINFO:
+		d.respondInfo(cmd)
+		if d.booting != "" {
+			d.Info.ROM, d.booting = d.booting, ""
+		}
+		return
+	case OpBOOT:
+		name := cleanPath(cString(cmd[256:]))
+		if _, ok := d.Files[name]; !ok {
+			d.respond(cmd, false, 0)
+			return
+		}
+		// the menu shows while the ROM loads:
+		d.Info.ROM, d.booting = MenuROM, name
+		d.respond(cmd, true, 0)
+		return
+	case OpRESET, OpPOWER_CYCLE:
+		d.respond(cmd, true, 0)
+		return
+	case OpTIME:
+		rsp := makeHeader(OpRESPONSE, 0, FlagNONE)
+		if cmd[7] != 0 {
+			t, err := getRTC(cmd[rtcOffset:], time.Local)
+			if err != nil {
+				d.respond(cmd, false, 0)
+				return
+			}
+			d.ClockOffset = t.Sub(time.Now().Truncate(time.Second))
+		}
+		putRTC(rsp[rtcOffset:], time.Now().Add(d.ClockOffset))
+		d.out.Write(rsp)
+		return
+	case OpSRAM_ENABLE:
+		switch cmd[7] {
+		case sramDisable, sramEnable:
+			d.SRAMProtected = cmd[7] == sramDisable
+			d.respond(cmd, true, 0)
+		default:
+			d.respond(cmd, false, 0)
+		}
+		return
+	case OpSRAM_WRITE:
+		size, offset := getUint32(cmd[252:]), getUint32(cmd[256:])
+		mem := d.mem(SpaceSNES)[SRAMBase:WRAMBase]
+		if uint64(offset)+uint64(size) > uint64(len(mem)) {
+			d.respond(cmd, false, 0)
+			return
+		}
+		d.respond(cmd, true, size)
+		d.receive(int(size), BlockSize, func(data []byte) {
+			copy(mem[offset:], data)
+		})
+		return
+	case OpSTREAM:
+		if cmd[7] == 0 {
+			d.sendStream(nil)
+			return
+		}
+		d.respond(cmd, true, 0)
+		if len(d.Stream) > 0 {
+			d.sendStream(d.Stream)
+		}
+		return
+	case OpMENU_RESET:
+		d.Info.ROM, d.booting = MenuROM, ""
+		d.respond(cmd, true, 0)
+		return
+	}
+
+	if space == SpaceFILE {
+		d.handleFile(op, cmd)
+		return
+	}
+
+	mem := d.mem(space)
+	switch op {
+	case OpGET:
+		size, addr := getUint32(cmd[252:]), getUint32(cmd[256:])
+		if uint64(addr)+uint64(size) > uint64(len(mem)) {
+			d.respond(cmd, false, 0)
+			return
+		}
+		d.respond(cmd, true, size)
+		d.send(mem[addr:addr+size], BlockSize)
+	case OpPUT:
+		size, addr := getUint32(cmd[252:]), getUint32(cmd[256:])
+		if uint64(addr)+uint64(size) > uint64(len(mem)) {
+			d.respond(cmd, false, 0)
+			return
+		}
+		d.respond(cmd, true, size)
+		d.receive(int(size), BlockSize, func(data []byte) {
+			copy(mem[addr:], data)
+		})
+	case OpVGET:
+		var data []byte
+		for i := 32; i+4 <= len(cmd); i += 4 {
+			size := int(cmd[i])
+			if i == 32 {
+				size = getSize9(cmd, i)
+			}
+			addr := int(cmd[i+1])<<16 | int(cmd[i+2])<<8 | int(cmd[i+3])
+			if size == 0 {
+				continue
+			}
+			if addr+size > len(mem) {
+				// answer even though FlagNORESP asked for no response, so the caller
+				// gets an error instead of waiting for data that never comes:
+				rsp := makeHeader(OpRESPONSE, 0, FlagDATA64B)
+				rsp[5] = 1
+				d.out.Write(rsp)
+				return
+			}
+			data = append(data, mem[addr:addr+size]...)
+		}
+		d.respond(cmd, true, uint32(len(data)))
+		d.send(data, Block64Size)
+	default:
+		d.respond(cmd, false, 0)
+	}
+}
+
+func (d *MemDevice) mem(space Space) []byte {
+	mem, ok := d.Mem[space]
+	if !ok {
+		mem = make([]byte, memSpaceSize)
+		d.Mem[space] = mem
+	}
+	return mem
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func (d *MemDevice) handleFile(op Opcode, cmd []byte) {
+	name := cleanPath(cString(cmd[256:]))
+	_, isFile := d.Files[name]
+	isDir := d.Dirs[name]
+	parentIsDir := d.Dirs[path.Dir(name)]
+
+	switch op {
+	case OpLS:
+		if !isDir {
+			d.respond(cmd, false, 0)
+			return
+		}
+		d.respond(cmd, true, 0)
+		d.send(d.listing(name), BlockSize)
+
+	case OpGET:
+		if !isFile {
+			d.respond(cmd, false, 0)
+			return
+		}
+		data := d.Files[name]
+		d.respond(cmd, true, uint32(len(data)))
+		d.send(data, BlockSize)
+
+	case OpPUT:
+		if isDir || !parentIsDir {
+			d.respond(cmd, false, 0)
+			return
+		}
+		size := getUint32(cmd[252:])
+		d.respond(cmd, true, size)
+		d.receive(int(size), BlockSize, func(data []byte) {
+			d.Files[name] = append([]byte(nil), data...)
+		})
+
+	case OpMKDIR:
+		if isFile || isDir || !parentIsDir {
+			d.respond(cmd, false, 0)
+			return
+		}
+		d.Dirs[name] = true
+		d.respond(cmd, true, 0)
+
+	case OpRM:
+		switch {
+		case isFile:
+			delete(d.Files, name)
+		case isDir && name != "/" && len(d.children(name)) == 0:
+			delete(d.Dirs, name)
+		default:
+			d.respond(cmd, false, 0)
+			return
+		}
+		d.respond(cmd, true, 0)
+
+	case OpMV:
+		newName := cleanPath(cString(cmd[8:]))
+		_, newIsFile := d.Files[newName]
+		if (!isFile && !isDir) || name == "/" || newIsFile || d.Dirs[newName] || !d.Dirs[path.Dir(newName)] {
+			d.respond(cmd, false, 0)
+			return
+		}
+		if isFile {
+			d.Files[newName] = d.Files[name]
+			delete(d.Files, name)
+		} else {
+			prefix := name + "/"
+			for p, data := range d.Files {
+				if strings.HasPrefix(p, prefix) {
+					d.Files[newName+"/"+p[len(prefix):]] = data
+					delete(d.Files, p)
+				}
+			}
+			for p := range d.Dirs {
+				if strings.HasPrefix(p, prefix) {
+					d.Dirs[newName+"/"+p[len(prefix):]] = true
+					delete(d.Dirs, p)
+				}
+			}
+			delete(d.Dirs, name)
+			d.Dirs[newName] = true
+		}
+		d.respond(cmd, true, 0)
+
+	default:
+		d.respond(cmd, false, 0)
+	}
+}
+
+// children returns the sorted entries directly inside dir.
+func (d *MemDevice) children(dir string) (entries []DirEntry) {
+	for p := range d.Dirs {
+		if p != "/" && path.Dir(p) == dir {
+			entries = append(entries, DirEntry{Type: FtDIRECTORY, Name: path.Base(p)})
+		}
+	}
+	for p := range d.Files {
+		if path.Dir(p) == dir {
+			entries = append(entries, DirEntry{Type: FtFILE, Name: path.Base(p)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return
+}
+
+// listing encodes an LS reply the way the firmware does: entries never straddle a
+// block; $02 continues in the next block and $FF ends the listing.
+func (d *MemDevice) listing(dir string) []byte {
+	entries := append([]DirEntry{
+		{Type: FtDIRECTORY, Name: "."},
+		{Type: FtDIRECTORY, Name: ".."},
+	}, d.children(dir)...)
+
+	var out []byte
+	block := make([]byte, 0, BlockSize)
+	for _, e := range entries {
+		n := 1 + len(e.Name) + 1
+		if len(block)+n+1 > BlockSize {
+			block = append(block, 0x02)
+			out = append(out, block...)
+			out = append(out, make([]byte, BlockSize-len(block))...)
+			block = block[:0]
+		}
+		block = append(block, byte(e.Type))
+		block = append(block, e.Name...)
+		block = append(block, 0)
+	}
+	block = append(block, 0xFF)
+	return append(out, block...)
+}
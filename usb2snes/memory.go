@@ -0,0 +1,137 @@
+package usb2snes
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Get reads size bytes starting at addr in space.
+func (c *Conn) Get(space Space, addr uint32, size uint32) ([]byte, error) {
+	rsp, err := c.command(MakeGET(space, addr, size))
+	if err != nil {
+		return nil, err
+	}
+
+	// the response header carries the size that will actually be sent:
+	n := getUint32(rsp[252:])
+	if n != size {
+		return nil, fmt.Errorf("get: requested %d bytes but device will send %d", size, n)
+	}
+
+	return c.readData(int(size), BlockSize)
+}
+
+// VGet reads size bytes starting at addr in SpaceSNES with a single-range VGET, which
+// skips the response header and pads to 64-byte blocks. size is at most MaxVGETSize.
+func (c *Conn) VGet(addr uint32, size int) ([]byte, error) {
+	data, err := c.VGetRanges([]VGETRange{{Addr: addr, Size: size}})
+	if err != nil {
+		return nil, err
+	}
+	return data[0], nil
+}
+
+// VGetRanges reads several ranges of SpaceSNES with one VGET command and returns the
+// data of each range in order. See MakeVGETRanges for the limits on ranges.
+func (c *Conn) VGetRanges(ranges []VGETRange) ([][]byte, error) {
+	sb, err := MakeVGETRanges(ranges)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, r := range ranges {
+		total += r.Size
+	}
+
+	if err = c.writeChunk(sb); err != nil {
+		return nil, err
+	}
+	data, err := c.readData(total, Block64Size)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		out[i], data = data[:r.Size], data[r.Size:]
+	}
+	return out, nil
+}
+
+// Put writes data starting at addr in space. The payload is streamed after the command
+// header in 512-byte blocks; the last block is zero-padded.
+func (c *Conn) Put(space Space, addr uint32, data []byte) error {
+	return c.put(space, addr, data, FlagNONE)
+}
+
+func (c *Conn) put(space Space, addr uint32, data []byte, flags Flags) error {
+	sb := MakePUT(space, addr, uint32(len(data)))
+	sb[6] |= byte(flags)
+	if _, err := c.command(sb); err != nil {
+		return err
+	}
+
+	return c.writeData(data, BlockSize)
+}
+
+// VerifyError reports the first byte that differs between the expected data and what
+// was read back from the device.
+type VerifyError struct {
+	Space  Space
+	Addr   uint32
+	Offset int
+	Want   byte
+	Got    byte
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf(
+		"verify: mismatch at offset $%x (address $%06x): wrote $%02x, read $%02x",
+		e.Offset,
+		e.Addr+uint32(e.Offset),
+		e.Want,
+		e.Got,
+	)
+}
+
+// Verify reads back len(data) bytes from addr in space and compares them to data.
+// A mismatch is reported as a *VerifyError.
+func (c *Conn) Verify(space Space, addr uint32, data []byte) error {
+	got, err := c.Get(space, addr, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	if i := firstDiff(data, got); i >= 0 {
+		return &VerifyError{
+			Space:  space,
+			Addr:   addr,
+			Offset: i,
+			Want:   data[i],
+			Got:    got[i],
+		}
+	}
+	return nil
+}
+
+// PutVerify writes data with Put and then checks it with Verify.
+func (c *Conn) PutVerify(space Space, addr uint32, data []byte) error {
+	if err := c.Put(space, addr, data); err != nil {
+		return err
+	}
+	return c.Verify(space, addr, data)
+}
+
+// firstDiff returns the index of the first byte where a and b differ or -1 if they
+// are equal. a and b must be the same length.
+func firstDiff(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,94 @@
+package usb2snes
+
+import (
+	"fmt"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Reset resets the running game. flags may include FlagSKIPRESET or FlagONLYRESET,
+// which are passed to the firmware as is.
+func (c *Conn) Reset(flags Flags) error {
+	_, err := c.command(makeHeader(OpRESET, SpaceSNES, flags))
+	return err
+}
+
+// MenuReset returns to the FX Pak Pro menu.
+func (c *Conn) MenuReset(flags Flags) error {
+	_, err := c.command(makeHeader(OpMENU_RESET, SpaceSNES, flags))
+	return err
+}
+
+// PowerCycle power cycles the cart. The USB device goes away with it so no response is
+// waited for; follow up with Reconnect.
+func (c *Conn) PowerCycle(flags Flags) error {
+	return c.writeChunk(makeHeader(OpPOWER_CYCLE, SpaceSNES, flags|FlagNORESP))
+}
+
+// WaitReady polls OpINFO until the device answers or timeout expires.
+func (c *Conn) WaitReady(timeout time.Duration) (info Info, err error) {
+	const interval = 100 * time.Millisecond
+
+	_ = c.SetReadTimeout(interval)
+	defer c.SetReadTimeout(0)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if info, err = c.Info(); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			return info, fmt.Errorf("timed out waiting for device: %w", err)
+		}
+
+		c.drain()
+		time.Sleep(interval)
+	}
+}
+
+// Reconnect closes the serial port, waits for the device to drop off the bus and come
+// back, and reopens it. The device is found again by its serial number as the port name
+// may change when it re-enumerates. Transports other than serial ports are only waited
+// on with WaitReady. If the device does not come back the Conn is left closed.
+func (c *Conn) Reconnect(timeout time.Duration) error {
+	const interval = 250 * time.Millisecond
+
+	if _, ok := c.rw.(serial.Port); !ok {
+		_, err := c.WaitReady(timeout)
+		return err
+	}
+
+	c.Close()
+	deadline := time.Now().Add(timeout)
+
+	// give the device up to a few seconds to disappear so the old instance is not
+	// mistaken for the new one:
+	for gone := time.Now().Add(5 * time.Second); time.Now().Before(gone); {
+		if _, err := FindPort(); err == ErrNotFound {
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	var lastErr error
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		nc, err := OpenDevice("")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err = nc.WaitReady(time.Second); err != nil {
+			nc.Close()
+			lastErr = err
+			continue
+		}
+
+		c.Name, c.rw = nc.Name, nc.rw
+		return nil
+	}
+
+	return fmt.Errorf("timed out waiting for device to come back: %w", lastErr)
+}
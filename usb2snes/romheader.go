@@ -0,0 +1,79 @@
+package usb2snes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where the FX Pak Pro maps the cart's memories in SpaceSNES:
+const (
+	ROMBase  = 0x000000
+	SRAMBase = 0xE00000
+	WRAMBase = 0xF50000
+)
+
+// ROMHeader is the internal header of the running ROM.
+type ROMHeader struct {
+	// Addr is where in SpaceSNES the header was found.
+	Addr     uint32
+	Title    string
+	MapMode  byte
+	CartType byte
+	// ROMSize and SRAMSize are in bytes; SRAMSize is 0 if the cart has no SRAM.
+	ROMSize  int
+	SRAMSize int
+	Checksum uint16
+}
+
+// Candidate header locations in ROM for LoROM, HiROM and ExHiROM:
+var romHeaderAddrs = [...]uint32{0x007FC0, 0x00FFC0, 0x40FFC0}
+
+// ParseROMHeader decodes the 64-byte header block at addr, which must hold the header
+// fields starting at $xxC0. It fails if the checksum and its complement do not match.
+func ParseROMHeader(addr uint32, b []byte) (h ROMHeader, err error) {
+	if len(b) < 0x20 {
+		return h, fmt.Errorf("rom header: need 32 bytes, got %d", len(b))
+	}
+
+	complement := uint16(b[0x1C]) | uint16(b[0x1D])<<8
+	checksum := uint16(b[0x1E]) | uint16(b[0x1F])<<8
+	if checksum^complement != 0xFFFF {
+		return h, fmt.Errorf("rom header: no valid header at $%06x", addr)
+	}
+
+	h.Addr = addr
+	h.Title = strings.TrimRight(string(b[0x00:0x15]), " \x00")
+	h.MapMode = b[0x15]
+	h.CartType = b[0x16]
+	if b[0x17] != 0 && b[0x17] < 16 {
+		h.ROMSize = 1024 << b[0x17]
+	}
+	if b[0x18] != 0 && b[0x18] < 16 {
+		h.SRAMSize = 1024 << b[0x18]
+	}
+	h.Checksum = checksum
+	return
+}
+
+// ROMHeader finds and decodes the running ROM's header.
+func (c *Conn) ROMHeader() (ROMHeader, error) {
+	for _, addr := range romHeaderAddrs {
+		b, err := c.Get(SpaceSNES, ROMBase+addr, 0x40)
+		if err != nil {
+			return ROMHeader{}, err
+		}
+		if h, err := ParseROMHeader(addr, b); err == nil {
+			return h, nil
+		}
+	}
+	return ROMHeader{}, fmt.Errorf("rom header: not found")
+}
+
+// SRAMSize returns the size in bytes of the running ROM's SRAM according to its header.
+func (c *Conn) SRAMSize() (int, error) {
+	h, err := c.ROMHeader()
+	if err != nil {
+		return 0, err
+	}
+	return h.SRAMSize, nil
+}
@@ -0,0 +1,53 @@
+package usb2snes
+
+import (
+	"fmt"
+	"time"
+)
+
+// The RTC travels as a 8-byte struct at offset 256 of the OpTIME command or response:
+// second, minute, hour, day of month, month (1-12), year (big endian) and day of week.
+// Byte 7 of the command is 1 to set the clock and 0 to read it.
+const rtcOffset = 256
+
+func putRTC(b []byte, t time.Time) {
+	b[0] = byte(t.Second())
+	b[1] = byte(t.Minute())
+	b[2] = byte(t.Hour())
+	b[3] = byte(t.Day())
+	b[4] = byte(t.Month())
+	b[5] = byte(t.Year() >> 8)
+	b[6] = byte(t.Year())
+	b[7] = byte(t.Weekday())
+}
+
+func getRTC(b []byte, loc *time.Location) (time.Time, error) {
+	year := int(b[5])<<8 | int(b[6])
+	t := time.Date(year, time.Month(b[4]), int(b[3]), int(b[2]), int(b[1]), int(b[0]), 0, loc)
+	if t.Second() != int(b[0]) || t.Minute() != int(b[1]) || t.Hour() != int(b[2]) ||
+		t.Day() != int(b[3]) || int(t.Month()) != int(b[4]) {
+		return t, fmt.Errorf("rtc: invalid date %04d-%02d-%02d %02d:%02d:%02d", year, b[4], b[3], b[2], b[1], b[0])
+	}
+	return t, nil
+}
+
+// Time reads the cart's real-time clock. The clock has no notion of time zones so
+// the result is interpreted in loc.
+func (c *Conn) Time(loc *time.Location) (time.Time, error) {
+	sb := makeHeader(OpTIME, SpaceSNES, FlagNONE)
+	rsp, err := c.command(sb)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return getRTC(rsp[rtcOffset:], loc)
+}
+
+// SetTime sets the cart's real-time clock to the wall clock time of t in its own
+// location.
+func (c *Conn) SetTime(t time.Time) error {
+	sb := makeHeader(OpTIME, SpaceSNES, FlagNONE)
+	sb[7] = 1
+	putRTC(sb[rtcOffset:], t)
+	_, err := c.command(sb)
+	return err
+}
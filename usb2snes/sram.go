@@ -0,0 +1,115 @@
+package usb2snes
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Argument in byte 7 of OpSRAM_ENABLE:
+const (
+	sramDisable = 0
+	sramEnable  = 1
+)
+
+// ErrUnsupported is returned for commands the firmware rejects because it does not
+// implement them.
+var ErrUnsupported = errors.New("usb2snes: not supported by this firmware")
+
+// SetSRAMWrites enables or disables writes to cart SRAM.
+func (c *Conn) SetSRAMWrites(enabled bool) error {
+	sb := makeHeader(OpSRAM_ENABLE, SpaceSNES, FlagNONE)
+	sb[7] = sramDisable
+	if enabled {
+		sb[7] = sramEnable
+	}
+	if _, err := c.command(sb); err != nil {
+		return err
+	}
+	c.sramProtected = !enabled
+	return nil
+}
+
+// SRAMWritesEnabled reports whether SRAM writes are enabled as far as this Conn knows.
+// The firmware cannot be asked, so this is the last state set through SetSRAMWrites or
+// otherwise the firmware's default of enabled.
+func (c *Conn) SRAMWritesEnabled() bool {
+	return !c.sramProtected
+}
+
+// SRAMGuard undoes ProtectSRAM.
+type SRAMGuard struct {
+	c       *Conn
+	enabled bool
+	once    sync.Once
+	err     error
+}
+
+// ProtectSRAM disables SRAM writes and returns a guard whose Restore puts back the
+// state from before, as reported by SRAMWritesEnabled. Defer Restore straight away so
+// that the state is restored on every way out, including panics.
+func (c *Conn) ProtectSRAM() (*SRAMGuard, error) {
+	enabled := c.SRAMWritesEnabled()
+	if err := c.SetSRAMWrites(false); err != nil {
+		return nil, err
+	}
+	return &SRAMGuard{c: c, enabled: enabled}, nil
+}
+
+// Restore puts SRAM writes back the way ProtectSRAM found them. Only the first call
+// talks to the device; later calls return its result.
+func (g *SRAMGuard) Restore() error {
+	g.once.Do(func() {
+		g.err = g.c.SetSRAMWrites(g.enabled)
+	})
+	return g.err
+}
+
+// WriteSRAM uploads data to cart SRAM starting at offset with OpSRAM_WRITE. Unlike a
+// PUT to SRAMBase the firmware writes SRAM directly, regardless of what the game is
+// doing with it.
+func (c *Conn) WriteSRAM(offset uint32, data []byte) error {
+	sb := makeHeader(OpSRAM_WRITE, SpaceSNES, FlagNONE)
+	// size:
+	putUint32(sb[252:], uint32(len(data)))
+	// offset:
+	putUint32(sb[256:], offset)
+	if _, err := c.command(sb); err != nil {
+		return err
+	}
+
+	return c.writeData(data, BlockSize)
+}
+
+// RestoreSRAMOptions loosen the checks RestoreSRAM makes.
+type RestoreSRAMOptions struct {
+	// AnySize uploads data even if it is not the size of the running ROM's SRAM.
+	AnySize bool
+	// NoVerify skips reading SRAM back after the upload.
+	NoVerify bool
+}
+
+// RestoreSRAM checks that data is exactly the size of the running ROM's SRAM, uploads
+// it with WriteSRAM and reads it back to verify it.
+func (c *Conn) RestoreSRAM(data []byte, opts RestoreSRAMOptions) error {
+	if !opts.AnySize {
+		size, err := c.SRAMSize()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return fmt.Errorf("sram: running ROM has no SRAM")
+		}
+		if len(data) != size {
+			return fmt.Errorf("sram: save is %d bytes but the running ROM has %d bytes of SRAM", len(data), size)
+		}
+	}
+
+	if err := c.WriteSRAM(0, data); err != nil {
+		return err
+	}
+	if opts.NoVerify {
+		return nil
+	}
+	return c.Verify(SpaceSNES, SRAMBase, data)
+}
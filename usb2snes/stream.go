@@ -0,0 +1,156 @@
+package usb2snes
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// StreamSource selects what the firmware streams.
+type StreamSource uint8
+
+const (
+	// StreamWRAM streams CPU writes to WRAM.
+	StreamWRAM StreamSource = iota
+	// StreamBus streams every write the cart snoops on the SNES bus.
+	StreamBus
+)
+
+// StreamRecord is one write seen by the firmware.
+type StreamRecord struct {
+	Addr  uint32
+	Value byte
+}
+
+// The stream format is not documented; this assumes 64-byte packets of 4-byte records
+// (24-bit big-endian address, value), padded with address $FFFFFF, and a packet of only
+// padding to end the stream.
+const (
+	streamRecordSize = 4
+	streamPadAddr    = 0xFFFFFF
+)
+
+// Stream delivers records from a running OpSTREAM. Read them from C until it is
+// closed. The reader does not drop records: if C is full it stops reading the port and
+// USB flow control pushes back on the firmware.
+type Stream struct {
+	C <-chan StreamRecord
+
+	c        *Conn
+	stopOnce sync.Once
+	stopping chan struct{}
+	stopAt   time.Time
+	done     chan struct{}
+	readErr  error
+	stopErr  error
+}
+
+// streamStopGrace is how long the reader keeps going after a stop for firmware that
+// keeps sending without ever ending the stream.
+const streamStopGrace = time.Second
+
+// OpenStream starts streaming records from src into a channel holding up to buffer
+// records. The Conn must not be used for anything else until the stream is stopped.
+func (c *Conn) OpenStream(src StreamSource, burst bool, buffer int) (*Stream, error) {
+	flags := FlagNONE
+	if burst {
+		flags |= FlagSTREAM_BURST
+	}
+	sb := makeHeader(OpSTREAM, SpaceSNES, flags)
+	sb[7] = 1
+	sb[8] = byte(src)
+	if _, err := c.command(sb); err != nil {
+		return nil, err
+	}
+
+	// a short read timeout lets the reader notice a stop even if the firmware never
+	// sends the end packet:
+	if err := c.SetReadTimeout(100 * time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamRecord, buffer)
+	s := &Stream{
+		C:        ch,
+		c:        c,
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.read(ch)
+	return s, nil
+}
+
+func (s *Stream) read(ch chan<- StreamRecord) {
+	defer close(s.done)
+	defer close(ch)
+
+	packet := make([]byte, Block64Size)
+	for n := 0; ; {
+		m, err := s.c.rw.Read(packet[n:])
+		if err != nil && err != io.EOF {
+			s.readErr = fmt.Errorf("stream: %w", err)
+			return
+		}
+
+		select {
+		case <-s.stopping:
+			if m == 0 || time.Since(s.stopAt) > streamStopGrace {
+				return
+			}
+		default:
+		}
+
+		if m == 0 {
+			if err == io.EOF {
+				// in-memory transports do not block:
+				time.Sleep(time.Millisecond)
+			}
+			continue
+		}
+		if n += m; n < len(packet) {
+			continue
+		}
+		n = 0
+
+		records := 0
+		for i := 0; i < len(packet); i += streamRecordSize {
+			addr := uint32(packet[i])<<16 | uint32(packet[i+1])<<8 | uint32(packet[i+2])
+			if addr == streamPadAddr {
+				continue
+			}
+			records++
+			ch <- StreamRecord{Addr: addr, Value: packet[i+3]}
+		}
+		if records == 0 {
+			// end of stream:
+			return
+		}
+	}
+}
+
+// Stop ends the stream, discarding unread records, and returns the reader's first error.
+func (s *Stream) Stop() error {
+	s.stopOnce.Do(func() {
+		sb := makeHeader(OpSTREAM, SpaceSNES, FlagNORESP)
+		sb[7] = 0
+		s.stopErr = s.c.writeChunk(sb)
+		s.stopAt = time.Now()
+		close(s.stopping)
+	})
+
+	// drain so the reader is not stuck on a full channel:
+	for {
+		select {
+		case <-s.done:
+			// leftovers would be taken for the next command's response:
+			s.c.drain()
+			_ = s.c.SetReadTimeout(0)
+			if s.readErr != nil {
+				return s.readErr
+			}
+			return s.stopErr
+		case <-s.C:
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package usb2snes implements the host side of the FX Pak Pro (sd2snes) usb2snes
+// serial protocol.
+package usb2snes
+
+import "fmt"
+
+type Opcode uint8
+
+const (
+	OpGET Opcode = iota
+	OpPUT
+	OpVGET
+	OpVPUT
+
+	OpLS
+	OpMKDIR
+	OpRM
+	OpMV
+
+	OpRESET
+	OpBOOT
+	OpPOWER_CYCLE
+	OpINFO
+	OpMENU_RESET
+	OpSTREAM
+	OpTIME
+	OpRESPONSE
+
+	OpSRAM_ENABLE
+	OpSRAM_WRITE
+	OpIOVM_EXEC
+)
+
+type Space uint8
+
+const (
+	SpaceFILE Space = iota
+	SpaceSNES
+	SpaceMSU
+	SpaceCMD
+	SpaceCONFIG
+)
+
+type Flags uint8
+
+const FlagNONE Flags = 0
+const (
+	FlagSKIPRESET Flags = 1 << iota
+	FlagONLYRESET
+	FlagCLRX
+	FlagSETX
+	FlagSTREAM_BURST
+	FlagSIZE_BIT9
+	FlagNORESP
+	FlagDATA64B
+)
+
+type InfoFlags uint8
+
+const (
+	FeatDSPX InfoFlags = 1 << iota
+	FeatST0010
+	FeatSRTC
+	FeatMSU1
+	Feat213F
+	FeatCMD_UNLOCK
+	FeatUSB1
+	FeatDMA1
+)
+
+type FileType uint8
+
+const (
+	FtDIRECTORY FileType = 0
+	FtFILE      FileType = 1
+)
+
+// Sizes of the command header and the data blocks it is followed by:
+const (
+	BlockSize   = 512
+	Block64Size = 64
+)
+
+// PaddedSize rounds n up to the next multiple of block, the length of a padded
+// transfer of n bytes.
+func PaddedSize(n int, block int) int {
+	padded := (n / block) * block
+	if n%block != 0 {
+		padded += block
+	}
+	return padded
+}
+
+// makeHeader returns an empty command header for op; the header is 64 bytes long if
+// FlagDATA64B is set, otherwise 512 bytes.
+func makeHeader(op Opcode, space Space, flags Flags) []byte {
+	n := BlockSize
+	if flags&FlagDATA64B != 0 {
+		n = Block64Size
+	}
+	sb := make([]byte, n)
+	sb[0] = byte('U')
+	sb[1] = byte('S')
+	sb[2] = byte('B')
+	sb[3] = byte('A')
+	sb[4] = byte(op)
+	sb[5] = byte(space)
+	sb[6] = byte(flags)
+	return sb
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0] = byte((v >> 24) & 0xFF)
+	b[1] = byte((v >> 16) & 0xFF)
+	b[2] = byte((v >> 8) & 0xFF)
+	b[3] = byte((v >> 0) & 0xFF)
+}
+
+func getUint32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// MakeGET builds a GET command reading size bytes from addr in space.
+func MakeGET(space Space, addr uint32, size uint32) []byte {
+	sb := makeHeader(OpGET, space, FlagNONE)
+	// size:
+	putUint32(sb[252:], size)
+	// addr:
+	putUint32(sb[256:], addr)
+	return sb
+}
+
+// MakePUT builds a PUT command writing size bytes to addr in space.
+func MakePUT(space Space, addr uint32, size uint32) []byte {
+	sb := makeHeader(OpPUT, space, FlagNONE)
+	// size:
+	putUint32(sb[252:], size)
+	// addr:
+	putUint32(sb[256:], addr)
+	return sb
+}
+
+// MaxVGETSize is the most a single VGET range can read: its size field is one byte and
+// FlagSIZE_BIT9 supplies the ninth bit.
+const MaxVGETSize = 511
+
+// putSize9 stores a size of up to 511 in the one-byte field at sb[i] of a 64-byte mode
+// command and sets FlagSIZE_BIT9 if it needs the ninth bit. There is one flag per
+// command so only the first size field may use it.
+func putSize9(sb []byte, i int, size int) {
+	sb[i] = byte(size & 0xFF)
+	if size&0x100 != 0 {
+		sb[6] |= byte(FlagSIZE_BIT9)
+	}
+}
+
+// getSize9 reads a size stored by putSize9.
+func getSize9(sb []byte, i int) int {
+	size := int(sb[i])
+	if Flags(sb[6])&FlagSIZE_BIT9 != 0 {
+		size |= 0x100
+	}
+	return size
+}
+
+// MakeVGET builds a single-range VGET command in 64-byte mode. Sizes from 256 up to
+// MaxVGETSize set FlagSIZE_BIT9.
+func MakeVGET(addr uint32, size int) ([]byte, error) {
+	return MakeVGETRanges([]VGETRange{{Addr: addr, Size: size}})
+}
+
+// MaxVGETRanges is how many ranges fit in the 4-byte tuples from byte 32 of a 64-byte
+// VGET command.
+const MaxVGETRanges = 8
+
+// VGETRange is one address range of a VGET command.
+type VGETRange struct {
+	Addr uint32
+	Size int
+}
+
+// MakeVGETRanges builds a VGET command reading up to MaxVGETRanges ranges in one go.
+// Only the first range may exceed 255 bytes since it alone gets FlagSIZE_BIT9, and no
+// range may run past the end of the 24-bit address space.
+func MakeVGETRanges(ranges []VGETRange) ([]byte, error) {
+	if len(ranges) < 1 || len(ranges) > MaxVGETRanges {
+		return nil, fmt.Errorf("vget: %d ranges out of range 1..%d", len(ranges), MaxVGETRanges)
+	}
+	sb := makeHeader(OpVGET, SpaceSNES, FlagDATA64B|FlagNORESP)
+	for i, r := range ranges {
+		max := 255
+		if i == 0 {
+			max = MaxVGETSize
+		}
+		if r.Size < 1 || r.Size > max {
+			return nil, fmt.Errorf("vget: range %d size %d out of range 1..%d", i, r.Size, max)
+		}
+		if uint64(r.Addr)+uint64(r.Size) > 0x1000000 {
+			return nil, fmt.Errorf("vget: range %d of %d bytes at $%06X runs past $FFFFFF", i, r.Size, r.Addr)
+		}
+		// 4-byte struct: 1 byte size, 3 byte address
+		o := 32 + i*4
+		putSize9(sb, o, r.Size)
+		sb[o+1] = byte((r.Addr >> 16) & 0xFF)
+		sb[o+2] = byte((r.Addr >> 8) & 0xFF)
+		sb[o+3] = byte((r.Addr >> 0) & 0xFF)
+	}
+	return sb, nil
+}
@@ -0,0 +1,186 @@
+package usb2snes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMakeVGETSizes(t *testing.T) {
+	tests := []struct {
+		size    int
+		wantErr bool
+		bit9    bool
+		field   byte
+	}{
+		{size: 0, wantErr: true},
+		{size: 1, field: 0x01},
+		{size: 255, field: 0xFF},
+		{size: 256, bit9: true, field: 0x00},
+		{size: 511, bit9: true, field: 0xFF},
+		{size: 512, wantErr: true},
+	}
+	for _, tt := range tests {
+		sb, err := MakeVGET(0xF50010, tt.size)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("MakeVGET(size %d) succeeded, want error", tt.size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("MakeVGET(size %d): %v", tt.size, err)
+			continue
+		}
+
+		if len(sb) != Block64Size {
+			t.Errorf("size %d: command is %d bytes, want %d", tt.size, len(sb), Block64Size)
+		}
+		if got := Flags(sb[6])&FlagSIZE_BIT9 != 0; got != tt.bit9 {
+			t.Errorf("size %d: FlagSIZE_BIT9 = %v, want %v", tt.size, got, tt.bit9)
+		}
+		if sb[32] != tt.field {
+			t.Errorf("size %d: size field = $%02x, want $%02x", tt.size, sb[32], tt.field)
+		}
+		if got := getSize9(sb, 32); got != tt.size {
+			t.Errorf("size %d: getSize9 = %d", tt.size, got)
+		}
+		if !bytes.Equal(sb[33:36], []byte{0xF5, 0x00, 0x10}) {
+			t.Errorf("size %d: address = % x", tt.size, sb[33:36])
+		}
+	}
+}
+
+func TestMakeVGETRanges(t *testing.T) {
+	eight := make([]VGETRange, MaxVGETRanges)
+	for i := range eight {
+		eight[i] = VGETRange{Addr: 0xF50000 + uint32(i)*0x100, Size: 0x10 + i}
+	}
+
+	tests := []struct {
+		name    string
+		ranges  []VGETRange
+		wantErr bool
+	}{
+		{name: "none", wantErr: true},
+		{name: "eight", ranges: eight},
+		{name: "nine", ranges: append(eight[:MaxVGETRanges:MaxVGETRanges], VGETRange{Addr: 0xF50000, Size: 1}), wantErr: true},
+		{name: "large first", ranges: []VGETRange{{Addr: 0xF50000, Size: 511}, {Addr: 0xF60000, Size: 255}}},
+		{name: "large second", ranges: []VGETRange{{Addr: 0xF50000, Size: 1}, {Addr: 0xF60000, Size: 256}}, wantErr: true},
+		{name: "empty range", ranges: []VGETRange{{Addr: 0xF50000, Size: 1}, {Addr: 0xF60000, Size: 0}}, wantErr: true},
+		{name: "to end", ranges: []VGETRange{{Addr: 0xFFFFF0, Size: 0x10}}},
+		{name: "past end", ranges: []VGETRange{{Addr: 0xFFFFF0, Size: 0x20}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		sb, err := MakeVGETRanges(tt.ranges)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: succeeded, want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		for i, r := range tt.ranges {
+			o := 32 + i*4
+			size := int(sb[o])
+			if i == 0 {
+				size = getSize9(sb, o)
+			}
+			addr := uint32(sb[o+1])<<16 | uint32(sb[o+2])<<8 | uint32(sb[o+3])
+			if size != r.Size || addr != r.Addr {
+				t.Errorf("%s: range %d = %d bytes at $%06X, want %d at $%06X", tt.name, i, size, addr, r.Size, r.Addr)
+			}
+		}
+		for o := 32 + len(tt.ranges)*4; o < len(sb); o++ {
+			if sb[o] != 0 {
+				t.Errorf("%s: unused tuple byte %d = $%02x", tt.name, o, sb[o])
+			}
+		}
+	}
+}
+
+// TestMakeVGETRangesWire pins the command bytes themselves, which MemDevice cannot
+// check since it decodes them with the same rules.
+func TestMakeVGETRangesWire(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []VGETRange
+		flags  byte
+		tuples []byte
+	}{
+		{
+			name:   "small",
+			ranges: []VGETRange{{Addr: 0xF50010, Size: 2}, {Addr: 0xE01234, Size: 255}},
+			flags:  0xC0, // FlagDATA64B | FlagNORESP
+			tuples: []byte{0x02, 0xF5, 0x00, 0x10, 0xFF, 0xE0, 0x12, 0x34},
+		},
+		{
+			name:   "large first",
+			ranges: []VGETRange{{Addr: 0xF50000, Size: 300}, {Addr: 0xF60000, Size: 200}},
+			flags:  0xE0, // FlagDATA64B | FlagNORESP | FlagSIZE_BIT9
+			// only the first size has its high bit in the flags; the second stays 200:
+			tuples: []byte{0x2C, 0xF5, 0x00, 0x00, 0xC8, 0xF6, 0x00, 0x00},
+		},
+	}
+	for _, tt := range tests {
+		sb, err := MakeVGETRanges(tt.ranges)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		want := make([]byte, Block64Size)
+		copy(want, "USBA")
+		want[4] = 2 // OpVGET
+		want[5] = 1 // SpaceSNES
+		want[6] = tt.flags
+		copy(want[32:], tt.tuples)
+		if !bytes.Equal(sb, want) {
+			t.Errorf("%s: command\n% x\nwant\n% x", tt.name, sb, want)
+		}
+	}
+}
+
+func TestVGetRoundTrip(t *testing.T) {
+	d := NewMemDevice()
+	c := NewConn("mem", d)
+
+	mem := make([]byte, memSpaceSize)
+	for i := range mem {
+		mem[i] = byte(i * 7 >> 3)
+	}
+	d.Mem[SpaceSNES] = mem
+
+	for _, size := range []int{1, 63, 64, 255, 256, 300, 511} {
+		got, err := c.VGet(0xF50000, size)
+		if err != nil {
+			t.Fatalf("VGet(%d): %v", size, err)
+		}
+		if !bytes.Equal(got, mem[0xF50000:0xF50000+size]) {
+			t.Errorf("VGet(%d) returned the wrong bytes", size)
+		}
+	}
+
+	ranges := []VGETRange{
+		{Addr: 0xF50000, Size: 300},
+		{Addr: 0xE00000, Size: 255},
+		{Addr: 0xF5F340, Size: 1},
+		{Addr: 0xFFFFF0, Size: 0x10},
+	}
+	data, err := c.VGetRanges(ranges)
+	if err != nil {
+		t.Fatalf("VGetRanges: %v", err)
+	}
+	for i, r := range ranges {
+		if !bytes.Equal(data[i], mem[r.Addr:int(r.Addr)+r.Size]) {
+			t.Errorf("range %d returned the wrong bytes", i)
+		}
+	}
+
+	// the connection must still be in step after all of those:
+	if _, err = c.VGet(0xF50000, 1); err != nil {
+		t.Fatalf("VGet after VGetRanges: %v", err)
+	}
+}